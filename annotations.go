@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+const (
+	// RefreshAnnotation on the XR forces a query once for every new value,
+	// e.g. a timestamp
+	RefreshAnnotation = "azresourcegraph.fn.crossplane.io/refresh-requested-at"
+	// PauseAnnotation set to true on the XR skips queries and keeps the data
+	PauseAnnotation = "azresourcegraph.fn.crossplane.io/paused"
+)
+
+// observedAnnotation returns the value of the annotation of the observed XR,
+// empty if there is none.
+func observedAnnotation(req *fnv1.RunFunctionRequest, key string) string {
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		return ""
+	}
+	return oxr.Resource.GetAnnotations()[key]
+}
+
+// refreshRequested reports whether the refresh annotation has a value other
+// than the one recorded in the query metadata by the last query.
+func (f *Function) refreshRequested(req *fnv1.RunFunctionRequest, in *v1beta1.Input) bool {
+	requested := observedAnnotation(req, RefreshAnnotation)
+	if requested == "" {
+		return false
+	}
+	// Without metadata in the XR status every reconcile counts as requested
+	path := metadataPath(in)
+	if !strings.HasPrefix(path, "status.") {
+		return true
+	}
+	metadata, err := f.getTargetData(req, path)
+	if err != nil {
+		return true
+	}
+	m, _ := metadata.(map[string]interface{})
+	return m["refreshRequestedAt"] != requested
+}
+
+// withRefresh adds the refresh annotation to the query metadata fields, so a
+// query that handled the refresh is not run again for the same value.
+func withRefresh(req *fnv1.RunFunctionRequest, fields map[string]interface{}) map[string]interface{} {
+	if requested := observedAnnotation(req, RefreshAnnotation); requested != "" {
+		fields["refreshRequestedAt"] = requested
+	}
+	return fields
+}
+
+// shouldSkipQueryDueToPause checks if queries are paused by the pause
+// annotation. The context targets are restored from the query metadata if they
+// were cached.
+func (f *Function) shouldSkipQueryDueToPause(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
+	paused, _ := strconv.ParseBool(observedAnnotation(req, PauseAnnotation))
+	if !paused {
+		return false
+	}
+
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets while paused", "error", err)
+	}
+
+	f.log.Info("Skipping query as queries are paused", "annotation", PauseAnnotation)
+	response.ConditionTrue(rsp, skipCondition(in), "Paused").
+		WithMessage("Query skipped as the XR is annotated with " + PauseAnnotation).
+		TargetCompositeAndClaim()
+	return true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestAnnotations(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	intervalInput := `{
+		"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+		"kind": "Input",
+		"query": "Resources| count",
+		"target": "status.vnets",
+		"queryIntervalMinutes": 10
+	}`
+	recent := now.Add(-5 * time.Minute).Format(time.RFC3339)
+
+	// $fingerprint in the wanted status stands for the fingerprint of the Input
+	type args struct {
+		input string
+		xr    string
+		// rows the query returns, a single row if nil
+		rows []interface{}
+	}
+	type want struct {
+		status     string
+		context    string
+		results    []*fnv1.Result
+		skipReason string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"RefreshWithinInterval": {
+			reason: "A new refresh annotation value should query within the interval and be recorded in the query metadata",
+			args: args{
+				input: intervalInput,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `"}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 1,
+					"truncated": false,
+					"refreshRequestedAt": "1"
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"RefreshAlreadyDone": {
+			reason: "A refresh annotation value recorded in the query metadata should not query again",
+			args: args{
+				input: intervalInput,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `","refreshRequestedAt":"1"}}}`,
+			},
+			want: want{
+				status:     `{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `","refreshRequestedAt":"1"}}`,
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets","vnetsMetadata"]}}`,
+				skipReason: "IntervalLimit",
+			},
+		},
+		"RefreshWhenTargetHasData": {
+			reason: "A new refresh annotation value should query even if the target has data",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"skipQueryWhenTargetHasData": true
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"2"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"refreshRequestedAt":"1"}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 1,
+					"truncated": false,
+					"refreshRequestedAt": "2"
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"RefreshEmptyResultKept": {
+			reason: "A refresh whose empty result onEmpty keeps should be recorded so the next reconcile does not query again",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10,
+					"onEmpty": "keepPrevious"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `"}}}`,
+				rows: []interface{}{},
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + recent + `",
+					"stale": true,
+					"lastEmptyResultTime": "` + now.Format(time.RFC3339) + `",
+					"refreshRequestedAt": "1"
+				}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"RefreshKeptByLimit": {
+			reason: "A refresh whose result onLimitExceeded keeps should be recorded so the next reconcile does not query again",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10,
+					"maxRows": 1,
+					"onLimitExceeded": "keepPrevious"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `"}}}`,
+				rows: []interface{}{map[string]interface{}{"id": "/a"}, map[string]interface{}{"id": "/b"}},
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + recent + `","refreshRequestedAt":"1"}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "query returned 2 rows, exceeding maxRows 1: keeping the previous value",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"Paused": {
+			reason: "The pause annotation should skip the query and keep the data, even if a refresh is requested",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + PauseAnnotation + `":"true","` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}]}}`,
+			},
+			want: want{
+				status:     `{"vnets":[{"id":"/old"}]}`,
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				skipReason: "Paused",
+			},
+		},
+		"PausedRestoresContext": {
+			reason: "The pause annotation should restore cached context targets",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.vnets",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + PauseAnnotation + `":"true"}},
+					"status":{"vnetsMetadata":{"lastQueryTime":"` + now.Add(-time.Hour).Format(time.RFC3339) + `","cache":{"context.vnets":[{"id":"/old"}]}}}}`,
+			},
+			want: want{
+				status:     `{"vnetsMetadata":{"lastQueryTime":"` + now.Add(-time.Hour).Format(time.RFC3339) + `","cache":{"context.vnets":[{"id":"/old"}]}}}`,
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]},"vnets":[{"id":"/old"}]}`,
+				skipReason: "Paused",
+			},
+		},
+		"NotPaused": {
+			reason: "A pause annotation other than true should not skip the query",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + PauseAnnotation + `":"false"}},
+					"status":{"vnets":[{"id":"/old"}]}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"}]}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						rows := tc.args.rows
+						if rows == nil {
+							rows = []interface{}{map[string]interface{}{"id": "/a"}}
+						}
+						return armresourcegraph.ClientResourcesResponse{QueryResponse: armresourcegraph.QueryResponse{Data: rows}}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), reconcile(tc.args.input, tc.args.xr))
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			reason := ""
+			for _, c := range rsp.GetConditions() {
+				if c.GetType() == "FunctionSkip" {
+					reason = c.GetReason()
+				}
+			}
+			if diff := cmp.Diff(tc.want.skipReason, reason); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want skip reason, +got skip reason:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			want := strings.ReplaceAll(tc.want.status, "$fingerprint", inputFingerprint(t, tc.args.input))
+			if diff := cmp.Diff(resource.MustStructJSON(want), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// maxConditionNameLength matches the MaxLength of ConditionName, so the
+// condition types stay well within the 316 characters Kubernetes allows.
+const maxConditionNameLength = 48
+
+// conditionNameRegex matches the Pattern of ConditionName.
+var conditionNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// successCondition returns the type of the condition reporting whether the
+// query succeeded.
+func successCondition(in *v1beta1.Input) string {
+	return conditionPrefix(in) + "Success"
+}
+
+// skipCondition returns the type of the condition reporting why the query was
+// skipped.
+func skipCondition(in *v1beta1.Input) string {
+	return conditionPrefix(in) + "Skip"
+}
+
+// expectationsCondition returns the type of the condition reporting whether
+// the query result meets the expectations.
+func expectationsCondition(in *v1beta1.Input) string {
+	return conditionPrefix(in) + "Expectations"
+}
+
+// conditionPrefix returns the conditionName in CamelCase, e.g. QueryVnets for
+// query-vnets, or Function if it is not set.
+func conditionPrefix(in *v1beta1.Input) string {
+	words := strings.FieldsFunc(in.ConditionName, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "Function"
+	}
+	var b strings.Builder
+	for _, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(w[size:])
+	}
+	return b.String()
+}
+
+// validateConditionName checks the conditionName against the pattern and the
+// length the CRD declares, which Crossplane does not enforce on the Input.
+func validateConditionName(in *v1beta1.Input) error {
+	if in.ConditionName == "" {
+		return nil
+	}
+	if len(in.ConditionName) > maxConditionNameLength {
+		return errors.Errorf("conditionName %q must be at most %d characters", in.ConditionName, maxConditionNameLength)
+	}
+	if !conditionNameRegex.MatchString(in.ConditionName) {
+		return errors.Errorf("conditionName %q must start with a letter and contain only letters, digits, '_', '.' and '-'", in.ConditionName)
+	}
+	return nil
+}
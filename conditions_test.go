@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestConditionPrefix(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		name   string
+		want   string
+	}{
+		"Default": {
+			reason: "Without a conditionName the shared Function prefix should be used",
+			want:   "Function",
+		},
+		"StepName": {
+			reason: "A pipeline step name should be turned into CamelCase",
+			name:   "query-vnets",
+			want:   "QueryVnets",
+		},
+		"CamelCase": {
+			reason: "A CamelCase name should be kept",
+			name:   "VnetLookup",
+			want:   "VnetLookup",
+		},
+		"Separators": {
+			reason: "Dots and underscores should separate words too",
+			name:   "arg.lookup_2",
+			want:   "ArgLookup2",
+		},
+		"MultiByte": {
+			reason: "A word starting with a multi-byte letter should be capitalized whole rather than split",
+			name:   "étape-vnets",
+			want:   "ÉtapeVnets",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := conditionPrefix(&v1beta1.Input{ConditionName: tc.name})
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nconditionPrefix(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestValidateConditionName(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		name   string
+		want   string
+	}{
+		"Empty": {
+			reason: "No conditionName should be accepted",
+		},
+		"StepName": {
+			reason: "A pipeline step name should be accepted",
+			name:   "query-vnets.v2_a",
+		},
+		"LeadingDigit": {
+			reason: "A conditionName should start with a letter",
+			name:   "2-query",
+			want:   `conditionName "2-query" must start with a letter and contain only letters, digits, '_', '.' and '-'`,
+		},
+		"MultiByte": {
+			reason: "A conditionName should contain only ASCII letters",
+			name:   "étape",
+			want:   `conditionName "étape" must start with a letter and contain only letters, digits, '_', '.' and '-'`,
+		},
+		"TooLong": {
+			reason: "A conditionName should be at most 48 characters",
+			name:   strings.Repeat("a", 49),
+			want:   `conditionName "` + strings.Repeat("a", 49) + `" must be at most 48 characters`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := ""
+			if err := validateConditionName(&v1beta1.Input{ConditionName: tc.name}); err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nvalidateConditionName(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestConditionName(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	input := `{
+		"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+		"kind": "Input",
+		"query": "Resources| count",
+		"target": "status.vnets",
+		"queryIntervalMinutes": 10,
+		"conditionName": "query-vnets"
+	}`
+
+	cases := map[string]struct {
+		reason string
+		xr     string
+		want   []*fnv1.Condition
+	}{
+		"Success": {
+			reason: "A query should set the success condition of the conditionName",
+			xr:     `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			want: []*fnv1.Condition{{
+				Type:   "QueryVnetsSuccess",
+				Status: fnv1.Status_STATUS_CONDITION_TRUE,
+				Reason: "Success",
+				Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+			}},
+		},
+		"Skip": {
+			reason: "A skipped query should set the skip condition of the conditionName",
+			xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+				"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + now.Add(-time.Minute).Format(time.RFC3339) + `"}}}`,
+			want: []*fnv1.Condition{
+				{
+					Type:    "QueryVnetsSkip",
+					Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+					Reason:  "IntervalLimit",
+					Message: to.Ptr("Query skipped due to interval limit (10m0s)"),
+					Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+				},
+				{
+					Type:   "QueryVnetsSuccess",
+					Status: fnv1.Status_STATUS_CONDITION_TRUE,
+					Reason: "Success",
+					Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(map[string]interface{}{"id": "/a"}),
+				log:        logging.NewNopLogger(),
+				now:        func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.xr)}},
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want conditions, +got conditions:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
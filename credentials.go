@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+)
+
+const (
+	// ActiveDirectoryEndpointURL defines the azure credentials key for the Entra ID authority host
+	ActiveDirectoryEndpointURL = "activeDirectoryEndpointUrl"
+	// ResourceManagerEndpointURL defines the azure credentials key for the Azure Resource Manager endpoint
+	ResourceManagerEndpointURL = "resourceManagerEndpointUrl"
+
+	// Required resource keys used to request the ProviderConfig and its Secret
+	requiredProviderConfig       = "azure-provider-config"
+	requiredProviderConfigSecret = "azure-provider-config-secret"
+
+	defaultProviderConfigAPIVersion = "azure.upbound.io/v1beta1"
+	defaultProviderConfigKind       = "ProviderConfig"
+
+	// credentialsSourceSecret is the only ProviderConfig credentials source we can read
+	credentialsSourceSecret = "Secret"
+)
+
+// errProviderConfigPending is returned while Crossplane has not yet resolved
+// the required ProviderConfig or its Secret.
+var errProviderConfigPending = errors.New("waiting for required ProviderConfig resources")
+
+// parseCredentials parses credentials JSON in either the single service
+// principal format or as an array of service principals.
+func parseCredentials(credsJSON []byte) (interface{}, error) {
+	// Try to parse as array of service principals first
+	var servicePrincipals []map[string]string
+	if err := json.Unmarshal(credsJSON, &servicePrincipals); err == nil {
+		if len(servicePrincipals) == 0 {
+			return nil, errors.New("cannot parse json credentials: credentials array is empty")
+		}
+		return servicePrincipals, nil
+	}
+
+	// Fallback to single service principal format for backward compatibility
+	var singleCred map[string]string
+	if err := json.Unmarshal(credsJSON, &singleCred); err != nil {
+		return nil, errors.Wrap(safeJSONError(err), "cannot parse json credentials")
+	}
+	return singleCred, nil
+}
+
+// safeJSONError describes a JSON decoding error without echoing any part of
+// the input, which may contain secrets.
+func safeJSONError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return errors.Errorf("invalid JSON at offset %d", syntaxErr.Offset)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field != "" {
+			return errors.Errorf("field %q must be a %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return errors.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)
+	}
+	return errors.New("invalid JSON")
+}
+
+// identityTypeOf returns the identity type configured in the Input, defaulting
+// to service principal credentials.
+func identityTypeOf(in *v1beta1.Input) v1beta1.IdentityType {
+	if in.Identity != nil && in.Identity.Type != "" {
+		return in.Identity.Type
+	}
+	return v1beta1.IdentityTypeAzureServicePrincipalCredentials
+}
+
+// requiredCredentialFields returns the credential keys required by an
+// identity type. Workload identity requires none, as the token file, tenant
+// and client IDs default to the environment injected by Azure Workload
+// Identity when they are omitted.
+func requiredCredentialFields(identityType v1beta1.IdentityType) ([]string, error) {
+	switch identityType {
+	case v1beta1.IdentityTypeAzureServicePrincipalCredentials:
+		return []string{TenantID, ClientID, ClientSecret}, nil
+	case v1beta1.IdentityTypeAzureWorkloadIdentityCredentials:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unsupported identity.type: %s", string(identityType))
+	}
+}
+
+// validateCreds checks every credentials entry for the fields required by the
+// identity type. Errors name the missing fields and the array index of the
+// entry, but never include credential values.
+func validateCreds(azureCreds interface{}, identityType v1beta1.IdentityType) error {
+	required, err := requiredCredentialFields(identityType)
+	if err != nil {
+		return err
+	}
+
+	switch v := azureCreds.(type) {
+	case map[string]string:
+		if missing := missingFields(v, required); len(missing) > 0 {
+			return errors.Errorf("invalid credentials: missing required %s for identity type %s", describeFields(missing), identityType)
+		}
+	case []map[string]string:
+		if identityType == v1beta1.IdentityTypeAzureWorkloadIdentityCredentials && len(v) > 1 {
+			return errors.New("invalid credential format: workload identity support only one credentials entry")
+		}
+		var problems []string
+		for i, cred := range v {
+			if missing := missingFields(cred, required); len(missing) > 0 {
+				problems = append(problems, fmt.Sprintf("credentials[%d]: missing required %s", i, describeFields(missing)))
+			}
+		}
+		if len(problems) > 0 {
+			return errors.Errorf("invalid credentials for identity type %s: %s", identityType, strings.Join(problems, "; "))
+		}
+	default:
+		return errors.New("invalid credential format")
+	}
+	return nil
+}
+
+// missingFields returns the required keys that are absent or empty.
+func missingFields(cred map[string]string, required []string) []string {
+	var missing []string
+	for _, field := range required {
+		if strings.TrimSpace(cred[field]) == "" {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// describeFields formats a list of credential keys for an error message.
+func describeFields(fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = strconv.Quote(field)
+	}
+	if len(quoted) == 1 {
+		return "field " + quoted[0]
+	}
+	return "fields " + strings.Join(quoted, ", ")
+}
+
+// getProviderConfigCreds gets the credentials from the Secret referenced by
+// the ProviderConfig in the Input. Both resources are requested through
+// required resources, so the requirements are set on every invocation.
+func getProviderConfigCreds(req *fnv1.RunFunctionRequest, ref *v1beta1.ProviderConfigReference, rsp *fnv1.RunFunctionResponse) (interface{}, error) {
+	apiVersion := ref.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultProviderConfigAPIVersion
+	}
+	kind := ref.Kind
+	if kind == "" {
+		kind = defaultProviderConfigKind
+	}
+
+	requireResource(rsp, requiredProviderConfig, &fnv1.ResourceSelector{
+		ApiVersion: apiVersion,
+		Kind:       kind,
+		Match:      &fnv1.ResourceSelector_MatchName{MatchName: ref.Name},
+		Namespace:  ref.Namespace,
+	})
+
+	pc, err := getSingleRequiredResource(req, requiredProviderConfig)
+	if err != nil || pc == nil {
+		return nil, err
+	}
+
+	secretName, secretNamespace, secretKey, err := providerConfigSecretRef(pc, kind, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	requireResource(rsp, requiredProviderConfigSecret, &fnv1.ResourceSelector{
+		ApiVersion: "v1",
+		Kind:       "Secret",
+		Match:      &fnv1.ResourceSelector_MatchName{MatchName: secretName},
+		Namespace:  &secretNamespace,
+	})
+
+	secret, err := getSingleRequiredResource(req, requiredProviderConfigSecret)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	encoded, _, _ := unstructured.NestedString(secret.Object, "data", secretKey)
+	if encoded == "" {
+		return nil, errors.Errorf("secret %s/%s has no key %q", secretNamespace, secretName, secretKey)
+	}
+	credsJSON, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode key %q of secret %s/%s", secretKey, secretNamespace, secretName)
+	}
+
+	return parseCredentials(credsJSON)
+}
+
+// providerConfigSecretRef returns the name, namespace and key of the Secret a
+// ProviderConfig reads its credentials from.
+func providerConfigSecretRef(pc *unstructured.Unstructured, kind, name string) (string, string, string, error) {
+	source, _, _ := unstructured.NestedString(pc.Object, "spec", "credentials", "source")
+	if source != credentialsSourceSecret {
+		return "", "", "", errors.Errorf("%s %s: unsupported credentials source %q, only %q is supported", kind, name, source, credentialsSourceSecret)
+	}
+
+	secretName, _, _ := unstructured.NestedString(pc.Object, "spec", "credentials", "secretRef", "name")
+	secretKey, _, _ := unstructured.NestedString(pc.Object, "spec", "credentials", "secretRef", "key")
+	secretNamespace, _, _ := unstructured.NestedString(pc.Object, "spec", "credentials", "secretRef", "namespace")
+	if secretNamespace == "" {
+		// Namespaced ProviderConfigs reference Secrets in their own namespace
+		secretNamespace = pc.GetNamespace()
+	}
+	if secretName == "" || secretKey == "" {
+		return "", "", "", errors.Errorf("%s %s: spec.credentials.secretRef must specify name and key", kind, name)
+	}
+	if secretNamespace == "" {
+		return "", "", "", errors.Errorf("%s %s: spec.credentials.secretRef.namespace is required for a cluster scoped %s", kind, name, kind)
+	}
+	return secretName, secretNamespace, secretKey, nil
+}
+
+// requireResource adds a required resource selector to the response.
+func requireResource(rsp *fnv1.RunFunctionResponse, name string, selector *fnv1.ResourceSelector) {
+	if rsp.Requirements == nil {
+		rsp.Requirements = &fnv1.Requirements{}
+	}
+	if rsp.Requirements.Resources == nil {
+		rsp.Requirements.Resources = map[string]*fnv1.ResourceSelector{}
+	}
+	rsp.Requirements.Resources[name] = selector
+}
+
+// getSingleRequiredResource returns the required resource with the supplied
+// name. It returns errProviderConfigPending if Crossplane has not resolved the
+// requirement yet.
+func getSingleRequiredResource(req *fnv1.RunFunctionRequest, name string) (*unstructured.Unstructured, error) {
+	resources, resolved, err := request.GetRequiredResource(req, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get required resource %s", name)
+	}
+	if !resolved {
+		return nil, errProviderConfigPending
+	}
+	if len(resources) == 0 {
+		return nil, errors.Errorf("required resource %s was not found", name)
+	}
+	return resources[0].Resource, nil
+}
+
+// clientOptions returns client options targeting the cloud described by the
+// credentials endpoint URLs, falling back to Azure public cloud.
+func clientOptions(azureCreds map[string]string) azcore.ClientOptions {
+	adEndpoint := azureCreds[ActiveDirectoryEndpointURL]
+	rmEndpoint := azureCreds[ResourceManagerEndpointURL]
+	if adEndpoint == "" && rmEndpoint == "" {
+		return azcore.ClientOptions{}
+	}
+
+	cfg := cloud.AzurePublic
+	if adEndpoint != "" {
+		cfg.ActiveDirectoryAuthorityHost = adEndpoint
+	}
+	if rmEndpoint != "" {
+		cfg.Services = map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: rmEndpoint,
+				Endpoint: rmEndpoint,
+			},
+		}
+	}
+	return azcore.ClientOptions{Cloud: cfg}
+}
+
+// armClientOptions wraps clientOptions for the ResourceGraph client.
+func armClientOptions(azureCreds map[string]string) *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: clientOptions(azureCreds)}
+}
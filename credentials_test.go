@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestProviderConfigCredentials(t *testing.T) {
+	var (
+		xr    = `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`
+		input = `{
+	"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+	"kind": "Input",
+	"query": "Resources| count",
+	"target": "status.azResourceGraphQueryResult",
+	"providerConfigRef": {
+		"name": "default"
+	}
+}`
+		providerConfig = &fnv1.Resources{Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{
+	"apiVersion": "azure.upbound.io/v1beta1",
+	"kind": "ProviderConfig",
+	"metadata": {"name": "default"},
+	"spec": {
+		"credentials": {
+			"source": "Secret",
+			"secretRef": {"namespace": "crossplane-system", "name": "azure-secret", "key": "creds"}
+		}
+	}
+}`)}}}
+		// {"clientId":"client","clientSecret":"secret","tenantId":"tenant","subscriptionId":"sub","activeDirectoryEndpointUrl":"https://login.microsoftonline.com","resourceManagerEndpointUrl":"https://management.azure.com/"}
+		secret = &fnv1.Resources{Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{
+	"apiVersion": "v1",
+	"kind": "Secret",
+	"metadata": {"name": "azure-secret", "namespace": "crossplane-system"},
+	"data": {
+		"creds": "eyJjbGllbnRJZCI6ImNsaWVudCIsImNsaWVudFNlY3JldCI6InNlY3JldCIsInRlbmFudElkIjoidGVuYW50Iiwic3Vic2NyaXB0aW9uSWQiOiJzdWIiLCJhY3RpdmVEaXJlY3RvcnlFbmRwb2ludFVybCI6Imh0dHBzOi8vbG9naW4ubWljcm9zb2Z0b25saW5lLmNvbSIsInJlc291cmNlTWFuYWdlckVuZHBvaW50VXJsIjoiaHR0cHM6Ly9tYW5hZ2VtZW50LmF6dXJlLmNvbS8ifQ=="
+	}
+}`)}}}
+		pcRequirement = &fnv1.ResourceSelector{
+			ApiVersion: "azure.upbound.io/v1beta1",
+			Kind:       "ProviderConfig",
+			Match:      &fnv1.ResourceSelector_MatchName{MatchName: "default"},
+		}
+		secretRequirement = &fnv1.ResourceSelector{
+			ApiVersion: "v1",
+			Kind:       "Secret",
+			Match:      &fnv1.ResourceSelector_MatchName{MatchName: "azure-secret"},
+			Namespace:  to.Ptr("crossplane-system"),
+		}
+	)
+
+	type want struct {
+		requirements *fnv1.Requirements
+		results      []*fnv1.Result
+		creds        interface{}
+	}
+
+	cases := map[string]struct {
+		reason    string
+		input     string
+		resources map[string]*fnv1.Resources
+		want      want
+	}{
+		"RequestProviderConfig": {
+			reason: "The Function should require the ProviderConfig when it has not been resolved yet",
+			input:  input,
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig: pcRequirement,
+				}},
+			},
+		},
+		"RequestProviderConfigSecret": {
+			reason: "The Function should require the Secret referenced by a resolved ProviderConfig",
+			input:  input,
+			resources: map[string]*fnv1.Resources{
+				requiredProviderConfig: providerConfig,
+			},
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig:       pcRequirement,
+					requiredProviderConfigSecret: secretRequirement,
+				}},
+			},
+		},
+		"ProviderConfigNotFound": {
+			reason: "The Function should return a fatal result if the ProviderConfig does not exist",
+			input:  input,
+			resources: map[string]*fnv1.Resources{
+				requiredProviderConfig: {},
+			},
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig: pcRequirement,
+				}},
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "required resource azure-provider-config was not found",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"UnsupportedCredentialsSource": {
+			reason: "The Function should return a fatal result if the ProviderConfig does not use a Secret",
+			input:  input,
+			resources: map[string]*fnv1.Resources{
+				requiredProviderConfig: {Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{
+	"apiVersion": "azure.upbound.io/v1beta1",
+	"kind": "ProviderConfig",
+	"metadata": {"name": "default"},
+	"spec": {"credentials": {"source": "UserAssignedManagedIdentity"}}
+}`)}}},
+			},
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig: pcRequirement,
+				}},
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  `ProviderConfig default: unsupported credentials source "UserAssignedManagedIdentity", only "Secret" is supported`,
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"MissingSecretNamespace": {
+			reason: "The Function should return a fatal result if a cluster scoped ProviderConfig does not set the namespace of its Secret",
+			input:  input,
+			resources: map[string]*fnv1.Resources{
+				requiredProviderConfig: {Items: []*fnv1.Resource{{Resource: resource.MustStructJSON(`{
+	"apiVersion": "azure.upbound.io/v1beta1",
+	"kind": "ProviderConfig",
+	"metadata": {"name": "default"},
+	"spec": {
+		"credentials": {
+			"source": "Secret",
+			"secretRef": {"name": "azure-secret", "key": "creds"}
+		}
+	}
+}`)}}},
+			},
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig: pcRequirement,
+				}},
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "ProviderConfig default: spec.credentials.secretRef.namespace is required for a cluster scoped ProviderConfig",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"CredentialsFromProviderConfigSecret": {
+			reason: "The Function should parse the provider secret format into credentials",
+			input:  input,
+			resources: map[string]*fnv1.Resources{
+				requiredProviderConfig:       providerConfig,
+				requiredProviderConfigSecret: secret,
+			},
+			want: want{
+				requirements: &fnv1.Requirements{Resources: map[string]*fnv1.ResourceSelector{
+					requiredProviderConfig:       pcRequirement,
+					requiredProviderConfigSecret: secretRequirement,
+				}},
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  `Query: "Resources| count"`,
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+				creds: map[string]string{
+					ClientID:                   "client",
+					ClientSecret:               "secret",
+					TenantID:                   "tenant",
+					SubscriptionID:             "sub",
+					ActiveDirectoryEndpointURL: "https://login.microsoftonline.com",
+					ResourceManagerEndpointURL: "https://management.azure.com/",
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var gotCreds interface{}
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, azureCreds interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						gotCreds = azureCreds
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{
+								Data: []interface{}{map[string]interface{}{"Count": 1}},
+							},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:              &fnv1.RequestMeta{Tag: "hello"},
+				Input:             resource.MustStructJSON(tc.input),
+				Observed:          &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)}},
+				RequiredResources: tc.resources,
+			})
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+
+			if diff := cmp.Diff(tc.want.requirements, rsp.GetRequirements(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want requirements, +got requirements:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.creds, gotCreds); diff != "" {
+				t.Errorf("%s\nazQuery(...): -want creds, +got creds:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestValidateCreds(t *testing.T) {
+	type args struct {
+		creds        interface{}
+		identityType v1beta1.IdentityType
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   string
+	}{
+		"ValidServicePrincipal": {
+			reason: "Complete service principal credentials should be valid",
+			args: args{
+				creds:        map[string]string{TenantID: "t", ClientID: "c", ClientSecret: "s"},
+				identityType: v1beta1.IdentityTypeAzureServicePrincipalCredentials,
+			},
+		},
+		"MissingClientSecret": {
+			reason: "A missing client secret should be reported by field name",
+			args: args{
+				creds:        map[string]string{TenantID: "t", ClientID: "c"},
+				identityType: v1beta1.IdentityTypeAzureServicePrincipalCredentials,
+			},
+			want: `invalid credentials: missing required field "clientSecret" for identity type AzureServicePrincipalCredentials`,
+		},
+		"EmptyValuesAreMissing": {
+			reason: "Empty values should be reported as missing",
+			args: args{
+				creds:        map[string]string{TenantID: " ", ClientID: "", ClientSecret: "super-secret"},
+				identityType: v1beta1.IdentityTypeAzureServicePrincipalCredentials,
+			},
+			want: `invalid credentials: missing required fields "tenantId", "clientId" for identity type AzureServicePrincipalCredentials`,
+		},
+		"MissingFieldInArrayEntry": {
+			reason: "Problems in multiple service principals should be reported with their array index",
+			args: args{
+				creds: []map[string]string{
+					{TenantID: "t", ClientID: "c", ClientSecret: "s"},
+					{TenantID: "t", ClientSecret: "super-secret"},
+					{TenantID: "t", ClientID: "c"},
+				},
+				identityType: v1beta1.IdentityTypeAzureServicePrincipalCredentials,
+			},
+			want: `invalid credentials for identity type AzureServicePrincipalCredentials: credentials[1]: missing required field "clientId"; credentials[2]: missing required field "clientSecret"`,
+		},
+		"WorkloadIdentityFromEnvironment": {
+			reason: "Workload identity credentials may leave the federated token file to the environment",
+			args: args{
+				creds:        map[string]string{SubscriptionID: "s"},
+				identityType: v1beta1.IdentityTypeAzureWorkloadIdentityCredentials,
+			},
+		},
+		"WorkloadIdentityMultipleEntries": {
+			reason: "Workload identity supports a single credentials entry only",
+			args: args{
+				creds: []map[string]string{
+					{WorkloadIdentityCredentialPath: "/token"},
+					{WorkloadIdentityCredentialPath: "/token"},
+				},
+				identityType: v1beta1.IdentityTypeAzureWorkloadIdentityCredentials,
+			},
+			want: "invalid credential format: workload identity support only one credentials entry",
+		},
+		"UnsupportedIdentityType": {
+			reason: "Unknown identity types should be rejected",
+			args: args{
+				creds:        map[string]string{},
+				identityType: "Unknown",
+			},
+			want: "unsupported identity.type: Unknown",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := ""
+			if err := validateCreds(tc.args.creds, tc.args.identityType); err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nvalidateCreds(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestGetCreds(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		data   map[string][]byte
+		want   string
+	}{
+		"MissingCredentialsKey": {
+			reason: "A secret without the credentials key should be an error rather than nil credentials",
+			data:   map[string][]byte{"other": []byte(`{}`)},
+			want:   `azure-creds credentials have no "credentials" key`,
+		},
+		"EmptyArray": {
+			reason: "An empty credentials array should be an error",
+			data:   map[string][]byte{"credentials": []byte(`[]`)},
+			want:   "cannot parse json credentials: credentials array is empty",
+		},
+		"InvalidJSONDoesNotEchoSecret": {
+			reason: "JSON errors should not include any part of the secret",
+			data:   map[string][]byte{"credentials": []byte(`{"clientSecret": s3cr3t}`)},
+			want:   "cannot parse json credentials: invalid JSON at offset 18",
+		},
+		"WrongValueType": {
+			reason: "Type errors should name the field but not its value",
+			data:   map[string][]byte{"credentials": []byte(`{"clientSecret": 12345}`)},
+			want:   `cannot parse json credentials: field "clientSecret" must be a string, got number`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := getCreds(&fnv1.RunFunctionRequest{
+				Credentials: map[string]*fnv1.Credentials{
+					"azure-creds": {
+						Source: &fnv1.Credentials_CredentialData{CredentialData: &fnv1.CredentialData{Data: tc.data}},
+					},
+				},
+			})
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\ngetCreds(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
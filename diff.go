@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// rowDiff holds the keys of the rows added, removed or changed since the last
+// query.
+type rowDiff struct {
+	added, removed, changed []string
+}
+
+// empty reports whether no rows were added, removed or changed.
+func (d rowDiff) empty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+// diffOf returns the status target whose rows are compared.
+func diffOf(in *v1beta1.Input) string {
+	if in.Diff.Of != "" {
+		return in.Diff.Of
+	}
+	t, _ := firstStatusTarget(in)
+	return t
+}
+
+// validateDiff checks that the diff compares a status target and writes its
+// summary to a path no target or query metadata is written to.
+func (f *Function) validateDiff(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	if in.Diff == nil {
+		return nil
+	}
+
+	var err error
+	of := diffOf(in)
+	switch {
+	case in.Diff.KeyColumn == "":
+		err = errors.New("diff requires a keyColumn")
+	case !strings.HasPrefix(of, "status.") || !isTarget(in, of):
+		err = errors.Errorf("diff of %q must be a status target", of)
+	case !f.isValidTarget(in.Diff.Target) || isTarget(in, in.Diff.Target) || in.Diff.Target == metadataPath(in):
+		err = errors.Errorf("diff target %s must be a status or context field other than the targets and the query metadata", in.Diff.Target)
+	}
+	if err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+	return nil
+}
+
+// isTarget reports whether the query result is written to the path.
+func isTarget(in *v1beta1.Input, path string) bool {
+	for _, t := range inputTargets(in) {
+		if t.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// diffBaseline returns the value of the compared target before the query
+// result is written, nil without a diff.
+func diffBaseline(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) interface{} {
+	if in.Diff == nil {
+		return nil
+	}
+	v, _ := currentTargetValue(rsp, diffOf(in))
+	return v
+}
+
+// writeDiff compares the rows written to the compared target with its value
+// before, and writes the keys that changed along with a Normal result. Nothing
+// is written for the first query or when nothing changed, so the summary of
+// the last change is kept.
+func (f *Function) writeDiff(req *fnv1.RunFunctionRequest, in *v1beta1.Input, baseline interface{}, rsp *fnv1.RunFunctionResponse) error {
+	if in.Diff == nil || baseline == nil {
+		return nil
+	}
+	of := diffOf(in)
+	current, err := currentTargetValue(rsp, of)
+	if err != nil {
+		return err
+	}
+	d, err := diffRows(in.Diff.KeyColumn, baseline, current)
+	if err != nil {
+		return errors.Wrapf(err, "cannot diff %s", of)
+	}
+	if d.empty() {
+		return nil
+	}
+
+	f.log.Info("Query result changed", "target", of, "added", len(d.added), "removed", len(d.removed), "changed", len(d.changed))
+	response.Normalf(rsp, "Rows of %s changed: %d added, %d removed, %d changed", of, len(d.added), len(d.removed), len(d.changed))
+	err = f.putQueryResult(rsp, in.Diff.Target, map[string]interface{}{
+		"added":   toValues(d.added),
+		"removed": toValues(d.removed),
+		"changed": toValues(d.changed),
+		"time":    f.clock().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return recordWrittenStatus(req, rsp, in.Diff.Target)
+}
+
+// diffRows compares two arrays of rows by the key column. Rows without a
+// value for the key column are ignored.
+func diffRows(keyColumn string, before, after interface{}) (rowDiff, error) {
+	old, err := keyedRows(keyColumn, before)
+	if err != nil {
+		return rowDiff{}, err
+	}
+	current, err := keyedRows(keyColumn, after)
+	if err != nil {
+		return rowDiff{}, err
+	}
+
+	d := rowDiff{}
+	for k, row := range current {
+		prev, ok := old[k]
+		switch {
+		case !ok:
+			d.added = append(d.added, k)
+		case !reflect.DeepEqual(prev, row):
+			d.changed = append(d.changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := current[k]; !ok {
+			d.removed = append(d.removed, k)
+		}
+	}
+	sort.Strings(d.added)
+	sort.Strings(d.removed)
+	sort.Strings(d.changed)
+	return d, nil
+}
+
+// keyedRows returns the rows by the value of their key column.
+func keyedRows(keyColumn string, data interface{}) (map[string]map[string]interface{}, error) {
+	rows, err := toRows("diff", data)
+	if err != nil {
+		return nil, err
+	}
+	keyed := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		if v := row[keyColumn]; v != nil {
+			keyed[fmt.Sprint(v)] = row
+		}
+	}
+	return keyed, nil
+}
+
+// toValues converts the keys to values the targets accept.
+func toValues(keys []string) []interface{} {
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = k
+	}
+	return out
+}
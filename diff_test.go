@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	input := func(diff string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "Resources| count",
+			"target": "status.vnets",
+			"diff": ` + diff + `
+		}`
+	}
+	xr := func(status string) string {
+		return `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":` + status + `}`
+	}
+	rows := []interface{}{
+		map[string]interface{}{"id": "/b", "location": "northeurope"},
+		map[string]interface{}{"id": "/c", "location": "westeurope"},
+	}
+
+	type args struct {
+		input string
+		xr    string
+		data  []interface{}
+	}
+	type want struct {
+		status  string
+		context string
+		results []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Changed": {
+			reason: "Rows added, removed and changed since the last query should be written with a Normal result",
+			args: args{
+				input: input(`{"keyColumn": "id", "target": "status.vnetsDiff"}`),
+				xr:    xr(`{"vnets":[{"id":"/a","location":"westeurope"},{"id":"/b","location":"westeurope"}]}`),
+				data:  rows,
+			},
+			want: want{
+				status: `{
+					"vnets": [{"id":"/b","location":"northeurope"},{"id":"/c","location":"westeurope"}],
+					"vnetsDiff": {"added":["/c"],"removed":["/a"],"changed":["/b"],"time":"` + now.Format(time.RFC3339) + `"}
+				}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  "Rows of status.vnets changed: 1 added, 1 removed, 1 changed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"ContextTarget": {
+			reason: "The diff should be written to a context target",
+			args: args{
+				input: input(`{"keyColumn": "id", "target": "context.vnetsDiff"}`),
+				xr:    xr(`{"vnets":[{"id":"/b","location":"northeurope"}]}`),
+				data:  rows,
+			},
+			want: want{
+				status:  `{"vnets": [{"id":"/b","location":"northeurope"},{"id":"/c","location":"westeurope"}]}`,
+				context: `{"vnetsDiff": {"added":["/c"],"removed":[],"changed":[],"time":"` + now.Format(time.RFC3339) + `"}}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  "Rows of status.vnets changed: 1 added, 0 removed, 0 changed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"FirstQuery": {
+			reason: "Nothing should be diffed before the target has any data",
+			args: args{
+				input: input(`{"keyColumn": "id", "target": "status.vnetsDiff"}`),
+				xr:    xr(`{}`),
+				data:  rows,
+			},
+			want: want{
+				status:  `{"vnets": [{"id":"/b","location":"northeurope"},{"id":"/c","location":"westeurope"}]}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"Unchanged": {
+			reason: "The summary of the last change should be kept when no row changed",
+			args: args{
+				input: input(`{"keyColumn": "id", "target": "status.vnetsDiff"}`),
+				xr: xr(`{"vnets":[{"id":"/b","location":"northeurope"},{"id":"/c","location":"westeurope"}],
+					"vnetsDiff":{"added":["/c"],"removed":[],"changed":[],"time":"earlier"}}`),
+				data: rows,
+			},
+			want: want{
+				status: `{
+					"vnets": [{"id":"/b","location":"northeurope"},{"id":"/c","location":"westeurope"}],
+					"vnetsDiff": {"added":["/c"],"removed":[],"changed":[],"time":"earlier"}
+				}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsDiff"]}}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"MissingKeyColumn": {
+			reason: "A diff without a keyColumn should be fatal",
+			args: args{
+				input: input(`{"target": "status.vnetsDiff"}`),
+				xr:    xr(`{"vnets":[{"id":"/a"}]}`),
+				data:  rows,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "diff requires a keyColumn",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"TargetIsQueryTarget": {
+			reason: "A diff written to a query target should be fatal",
+			args: args{
+				input: input(`{"keyColumn": "id", "target": "status.vnets"}`),
+				xr:    xr(`{"vnets":[{"id":"/a"}]}`),
+				data:  rows,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "diff target status.vnets must be a status or context field other than the targets and the query metadata",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(tc.args.data...),
+				log:        logging.NewNopLogger(),
+				now:        func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), reconcile(tc.args.input, tc.args.xr))
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.status), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
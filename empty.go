@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// errEmptyResult is reported when the query returned no rows and onEmpty is
+// fatal.
+var errEmptyResult = errors.New("query returned no rows")
+
+// writeResults processes the query results, unless they are empty and the
+// targets keep their data as onEmpty asks, then notifies the targets that
+// changed.
+func (f *Function) writeResults(ctx context.Context, req *fnv1.RunFunctionRequest, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, info *queryInfo, rsp *fnv1.RunFunctionResponse) error {
+	kept, err := f.keepEmptyResult(req, in, results, rsp)
+	if err != nil || kept {
+		return err
+	}
+
+	// Taken before the targets and the query metadata are written
+	baseline := diffBaseline(in, rsp)
+	previous := recordedContentHashes(in, rsp)
+	if err := f.processResults(req, in, results, info, baseline, rsp); err != nil {
+		return err
+	}
+	if in.Notify != nil {
+		f.notifyChanges(ctx, req, in, previous, baseline, rsp)
+	}
+	return nil
+}
+
+// keepEmptyResult checks the result before it is processed and reports
+// whether the targets keep their data as onEmpty asks. A kept value is marked
+// as stale in the query metadata until the next result is written.
+func (f *Function) keepEmptyResult(req *fnv1.RunFunctionRequest, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, rsp *fnv1.RunFunctionResponse) (bool, error) {
+	if !emptyResult(results.Data) {
+		return false, nil
+	}
+
+	switch in.OnEmpty {
+	case v1beta1.EmptyActionKeepPrevious:
+		f.log.Info("Query returned no rows, keeping the previous data", "target", metadataPath(in))
+	case v1beta1.EmptyActionWarn:
+		response.Warning(rsp, errors.Wrap(errEmptyResult, "keeping the previous data"))
+	case v1beta1.EmptyActionFatal:
+		response.Fatal(rsp, errEmptyResult)
+		return false, errEmptyResult
+	default:
+		return false, nil
+	}
+
+	// The context does not survive between reconciles, restore what the last
+	// written result cached
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets after an empty result", "error", err)
+	}
+	if err := f.updateQueryMetadata(req, in, withRefresh(req, map[string]interface{}{
+		"stale":               true,
+		"lastEmptyResultTime": f.clock().Format(time.RFC3339),
+	}), rsp); err != nil {
+		err = errors.Wrap(err, "cannot write query metadata")
+		response.Fatal(rsp, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// emptyResult reports whether the query result has no rows.
+func emptyResult(data interface{}) bool {
+	switch d := data.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(d) == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestOnEmpty(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	earlier := now.Add(-time.Hour).Format(time.RFC3339)
+	input := func(target, onEmpty string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "Resources| count",
+			"target": "` + target + `",
+			"onEmpty": "` + onEmpty + `"
+		}`
+	}
+	xr := `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+		"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + earlier + `"}}}`
+	stale := `{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+		"lastQueryTime": "` + earlier + `",
+		"stale": true,
+		"lastEmptyResultTime": "` + now.Format(time.RFC3339) + `"
+	}}`
+
+	type args struct {
+		input string
+		xr    string
+		data  []interface{}
+	}
+	type want struct {
+		status  string
+		context string
+		results []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Write": {
+			reason: "An empty result should be written by default",
+			args: args{
+				input: input("status.vnets", "write"),
+				xr:    xr,
+				data:  []interface{}{},
+			},
+			want: want{
+				status: `{"vnets":[],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 0,
+					"truncated": false
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"KeepPrevious": {
+			reason: "An empty result should keep the data and mark it as stale",
+			args: args{
+				input: input("status.vnets", "keepPrevious"),
+				xr:    xr,
+			},
+			want: want{
+				status:  stale,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"Warn": {
+			reason: "An empty result should keep the data with a Warning",
+			args: args{
+				input: input("status.vnets", "warn"),
+				xr:    xr,
+				data:  []interface{}{},
+			},
+			want: want{
+				status:  stale,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "keeping the previous data: query returned no rows",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"Fatal": {
+			reason: "An empty result should be fatal and write nothing",
+			args: args{
+				input: input("status.vnets", "fatal"),
+				xr:    xr,
+				data:  []interface{}{},
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + earlier + `"}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets","vnetsMetadata"]}}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "query returned no rows",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"KeepPreviousRestoresContext": {
+			reason: "An empty result should restore the cached context targets",
+			args: args{
+				input: input("context.vnets", "keepPrevious"),
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+					"status":{"vnetsMetadata":{"lastQueryTime":"` + earlier + `","cache":{"context.vnets":[{"id":"/old"}]}}}}`,
+				data: []interface{}{},
+			},
+			want: want{
+				status: `{"vnetsMetadata":{
+					"lastQueryTime": "` + earlier + `",
+					"cache": {"context.vnets":[{"id":"/old"}]},
+					"stale": true,
+					"lastEmptyResultTime": "` + now.Format(time.RFC3339) + `"
+				}}`,
+				context: `{"vnets":[{"id":"/old"}]}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"NotEmpty": {
+			reason: "A result with rows should be written",
+			args: args{
+				input: input("status.vnets", "fatal"),
+				xr:    `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+				data:  []interface{}{map[string]interface{}{"id": "/a"}},
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"}]}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						var data interface{}
+						if tc.args.data != nil {
+							data = tc.args.data
+						}
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{Data: data},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), reconcile(tc.args.input, tc.args.xr))
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			want := strings.ReplaceAll(tc.want.status, "$fingerprint", inputFingerprint(t, tc.args.input))
+			if diff := cmp.Diff(resource.MustStructJSON(want), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
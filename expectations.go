@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// checkedRows returns the canonical rows of the query result, once they met
+// the expectations whose outcome is fatal.
+func (f *Function) checkedRows(in *v1beta1.Input, data interface{}, rsp *fnv1.RunFunctionResponse) (interface{}, error) {
+	rows, err := canonicalRows(in, data)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return nil, err
+	}
+	if err := f.checkExpectations(in, rows, rsp); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// checkExpectations checks the rows against the expectations and reports the
+// failed ones in the expectations condition and as their outcome asks. Only
+// a fatal outcome returns an error, so the rows are not written.
+func (f *Function) checkExpectations(in *v1beta1.Input, rows interface{}, rsp *fnv1.RunFunctionResponse) error {
+	if len(in.Expectations) == 0 {
+		return nil
+	}
+	rs, err := toRows("expectations", rows)
+	if err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+
+	var unmet []string
+	var fatal, notReady bool
+	for _, e := range in.Expectations {
+		msg := unmetExpectation(e, rs)
+		if msg == "" {
+			continue
+		}
+		unmet = append(unmet, msg)
+		switch e.Outcome {
+		case v1beta1.ExpectationOutcomeWarn:
+			response.Warning(rsp, errors.New(msg))
+		case v1beta1.ExpectationOutcomeNotReady:
+			notReady = true
+		default:
+			response.Fatal(rsp, errors.New(msg))
+			fatal = true
+		}
+	}
+
+	if len(unmet) > 0 {
+		f.log.Info("Query result does not meet the expectations", "unmet", unmet)
+	}
+	setExpectationsCondition(in, rsp, unmet, notReady)
+	if fatal {
+		return errors.New("query result does not meet the expectations")
+	}
+	return nil
+}
+
+// setExpectationsCondition reports the unmet expectations in the expectations
+// condition, and marks the XR as not ready if an outcome asks for it.
+func setExpectationsCondition(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse, unmet []string, notReady bool) {
+	if len(unmet) == 0 {
+		response.ConditionTrue(rsp, expectationsCondition(in), "ExpectationsMet").
+			TargetCompositeAndClaim()
+		return
+	}
+	response.ConditionFalse(rsp, expectationsCondition(in), "ExpectationsNotMet").
+		WithMessage(strings.Join(unmet, "; ")).
+		TargetCompositeAndClaim()
+	if notReady && rsp.GetDesired().GetComposite() != nil {
+		rsp.Desired.Composite.Ready = fnv1.Ready_READY_FALSE
+	}
+}
+
+// unmetExpectation describes the checks of the expectation the rows fail,
+// or returns an empty string if they meet it.
+func unmetExpectation(e v1beta1.Expectation, rows []map[string]interface{}) string {
+	failed := unmetRowCount(e, len(rows))
+	for _, column := range e.RequiredColumns {
+		if i, ok := firstNullRow(rows, column); ok {
+			failed = append(failed, fmt.Sprintf("required column %s is null in row %d", column, i))
+		}
+	}
+
+	switch {
+	case len(failed) == 0:
+		return ""
+	case e.Message != "":
+		return e.Message
+	default:
+		return strings.Join(failed, ", ")
+	}
+}
+
+// unmetRowCount describes the checks of the number of rows the result fails.
+func unmetRowCount(e v1beta1.Expectation, n int) []string {
+	var failed []string
+	if e.MinRows != nil && n < *e.MinRows {
+		failed = append(failed, fmt.Sprintf("expected at least %d rows, got %d", *e.MinRows, n))
+	}
+	if e.MaxRows != nil && n > *e.MaxRows {
+		failed = append(failed, fmt.Sprintf("expected at most %d rows, got %d", *e.MaxRows, n))
+	}
+	if e.ExactlyOne && n != 1 {
+		failed = append(failed, fmt.Sprintf("expected exactly one row, got %d", n))
+	}
+	return failed
+}
+
+// firstNullRow returns the index of the first row without a value for the
+// column.
+func firstNullRow(rows []map[string]interface{}, column string) (int, bool) {
+	for i, row := range rows {
+		if row[column] == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
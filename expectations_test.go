@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestExpectations(t *testing.T) {
+	xr := `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`
+	input := func(expectations string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "Resources| count",
+			"target": "status.vnets",
+			"expectations": ` + expectations + `
+		}`
+	}
+	condition := func(status fnv1.Status, reason, msg string) *fnv1.Condition {
+		c := &fnv1.Condition{
+			Type:   "FunctionExpectations",
+			Status: status,
+			Reason: reason,
+			Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+		}
+		if msg != "" {
+			c.Message = to.Ptr(msg)
+		}
+		return c
+	}
+	success := &fnv1.Condition{
+		Type:   "FunctionSuccess",
+		Status: fnv1.Status_STATUS_CONDITION_TRUE,
+		Reason: "Success",
+		Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+	}
+
+	type args struct {
+		input string
+		data  []interface{}
+	}
+	type want struct {
+		status     string
+		ready      fnv1.Ready
+		results    []*fnv1.Result
+		conditions []*fnv1.Condition
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Met": {
+			reason: "Rows meeting every expectation should be written",
+			args: args{
+				input: input(`[{"minRows": 1, "maxRows": 2, "requiredColumns": ["id"]}]`),
+				data:  []interface{}{map[string]interface{}{"id": "/a"}},
+			},
+			want: want{
+				status:     `{"vnets":[{"id":"/a"}]}`,
+				results:    []*fnv1.Result{queried},
+				conditions: []*fnv1.Condition{condition(fnv1.Status_STATUS_CONDITION_TRUE, "ExpectationsMet", ""), success},
+			},
+		},
+		"MinRowsFatal": {
+			reason: "Too few rows should be fatal by default and leave the target untouched",
+			args: args{
+				input: input(`[{"minRows": 1}]`),
+				data:  []interface{}{},
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}]}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "expected at least 1 rows, got 0",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{condition(fnv1.Status_STATUS_CONDITION_FALSE, "ExpectationsNotMet", "expected at least 1 rows, got 0")},
+			},
+		},
+		"ExactlyOneMessage": {
+			reason: "The message of the expectation should replace the description of the failed checks",
+			args: args{
+				input: input(`[{"exactlyOne": true, "message": "the vnet to import is ambiguous"}]`),
+				data:  []interface{}{map[string]interface{}{"id": "/a"}, map[string]interface{}{"id": "/b"}},
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}]}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "the vnet to import is ambiguous",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{condition(fnv1.Status_STATUS_CONDITION_FALSE, "ExpectationsNotMet", "the vnet to import is ambiguous")},
+			},
+		},
+		"RequiredColumnWarn": {
+			reason: "A null required column should emit a Warning and still write the rows",
+			args: args{
+				input: input(`[{"requiredColumns": ["id", "location"], "outcome": "warn"}]`),
+				data:  []interface{}{map[string]interface{}{"id": "/a", "location": "westeurope"}, map[string]interface{}{"id": "/b", "location": nil}},
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a","location":"westeurope"},{"id":"/b","location":null}]}`,
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "required column location is null in row 1",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{condition(fnv1.Status_STATUS_CONDITION_FALSE, "ExpectationsNotMet", "required column location is null in row 1"), success},
+			},
+		},
+		"MaxRowsNotReady": {
+			reason: "Too many rows should mark the XR as not ready and still write the rows",
+			args: args{
+				input: input(`[{"maxRows": 1, "outcome": "notReady"}, {"minRows": 1, "outcome": "warn"}]`),
+				data:  []interface{}{map[string]interface{}{"id": "/a"}, map[string]interface{}{"id": "/b"}},
+			},
+			want: want{
+				status:     `{"vnets":[{"id":"/a"},{"id":"/b"}]}`,
+				ready:      fnv1.Ready_READY_FALSE,
+				results:    []*fnv1.Result{queried},
+				conditions: []*fnv1.Condition{condition(fnv1.Status_STATUS_CONDITION_FALSE, "ExpectationsNotMet", "expected at most 1 rows, got 2"), success},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(tc.args.data...),
+				log:        logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.args.input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)}},
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.conditions, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want conditions, +got conditions:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.status), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.ready, rsp.GetDesired().GetComposite().GetReady()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want ready, +got ready:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -13,6 +12,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
@@ -21,6 +21,7 @@ import (
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
 	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
 	"github.com/crossplane/function-sdk-go/response"
 )
 
@@ -51,21 +52,50 @@ type Function struct {
 
 	azureQuery AzureQueryInterface
 
+	// notifier publishes change events, an HTTPNotifier if nil
+	notifier NotifierInterface
+
+	// notifyBudget bounds the time all change event deliveries of a call
+	// take together, defaultNotifyBudget if 0
+	notifyBudget time.Duration
+
+	// requireExplicitScope rejects tenant-wide queries unless the Input sets allowTenantScope
+	requireExplicitScope bool
+
+	// policy restricts the subscriptions, management groups and tables queries may touch
+	policy *Policy
+
+	// maxRows and maxBytes are the default result limits, 0 disables them
+	maxRows  int
+	maxBytes int
+
+	// minTTL and maxTTL bound the response TTL, 0 disables the bound
+	minTTL time.Duration
+	maxTTL time.Duration
+
+	// now returns the current time, time.Now if nil
+	now func() time.Time
+
 	log logging.Logger
 }
 
 // RunFunction runs the Function.
-func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest) (*fnv1.RunFunctionResponse, error) {
+func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest) (*fnv1.RunFunctionResponse, error) { //nolint:gocyclo // linear pipeline of steps that each return early on error
 	f.log.Info("Running function", "tag", req.GetMeta().GetTag())
 
 	rsp := response.To(req, response.DefaultTTL)
+	// response.To shares the desired state with the request, keep the request
+	// untouched as it is the merge base for mergeStrategy
+	if d, ok := proto.Clone(req.GetDesired()).(*fnv1.State); ok {
+		rsp.Desired = d
+	}
 
+	// Ensure the context is preserved
+	f.preserveContext(req, rsp)
 	// Ensure oxr to dxr gets propagated and we keep status around
 	if err := f.propagateDesiredXR(req, rsp); err != nil {
 		return rsp, nil //nolint:nilerr // errors are handled in rsp. We should not error main function and proceed with reconciliation
 	}
-	// Ensure the context is preserved
-	f.preserveContext(req, rsp)
 
 	// Parse input and get credentials
 	in, azureCreds, err := f.parseInputAndCredentials(req, rsp)
@@ -90,33 +120,41 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
-	// Check if target is valid
-	if !f.isValidTarget(in.Target) {
-		response.Fatal(rsp, errors.Errorf("Unrecognized target field: %s", in.Target))
-		return rsp, nil
+	// Apply the per-XR overrides, then check the targets and schedule
+	if err := f.validateInput(req, in, rsp); err != nil {
+		return rsp, nil //nolint:nilerr // errors are handled in rsp. We should not error main function and proceed with reconciliation
+	}
+
+	// Guard against accidental tenant-wide queries
+	if err := f.checkQueryScope(in, azureCreds, rsp); err != nil {
+		return rsp, nil //nolint:nilerr // errors are handled in rsp. We should not error main function and proceed with reconciliation
 	}
 
 	// Check if we should skip the query
-	if f.shouldSkipQuery(req, in, rsp) {
+	ctx, info := withQueryInfo(ctx, fingerprint(in, azureCreds))
+	if f.shouldSkipQuery(req, in, info, rsp) {
 		// Set success condition
-		response.ConditionTrue(rsp, "FunctionSuccess", "Success").
+		response.ConditionTrue(rsp, successCondition(in), "Success").
 			TargetCompositeAndClaim()
 		return rsp, nil
 	}
 
 	// Execute the query
-	results, err := f.executeQuery(ctx, azureCreds, in, rsp)
+	results, err := f.executeQuery(ctx, req, azureCreds, in, rsp)
 	if err != nil {
 		return rsp, nil //nolint:nilerr // errors are handled in rsp. We should not error main function and proceed with reconciliation
 	}
 
-	// Process the results
-	if err := f.processResults(req, in, results, rsp); err != nil {
+	// Process the results, unless onEmpty keeps the previous data
+	if err := f.writeResults(ctx, req, in, results, info, rsp); err != nil {
 		return rsp, nil //nolint:nilerr // errors are handled in rsp. We should not error main function and proceed with reconciliation
 	}
 
+	// Call the function again when the next query is due
+	f.setQueryTTL(req, in, rsp, f.clock())
+
 	// Set success condition
-	response.ConditionTrue(rsp, "FunctionSuccess", "Success").
+	response.ConditionTrue(rsp, successCondition(in), "Success").
 		TargetCompositeAndClaim()
 
 	return rsp, nil
@@ -137,24 +175,38 @@ func (f *Function) parseInputAndCredentials(req *fnv1.RunFunctionRequest, rsp *f
 		return nil, nil, err
 	}
 
-	azureCreds, err := getCreds(req)
+	var azureCreds interface{}
+	var err error
+	if in.ProviderConfigRef != nil {
+		azureCreds, err = getProviderConfigCreds(req, in.ProviderConfigRef, rsp)
+	} else {
+		azureCreds, err = getCreds(req)
+	}
+	if errors.Is(err, errProviderConfigPending) {
+		f.log.Info("Waiting for required ProviderConfig resources", "providerConfig", in.ProviderConfigRef.Name)
+		return nil, nil, err
+	}
 	if err != nil {
 		response.Fatal(rsp, err)
 		return nil, nil, err
 	}
 
+	// Validate credentials before they reach azidentity
+	if err := validateCreds(azureCreds, identityTypeOf(in)); err != nil {
+		response.Fatal(rsp, err)
+		return nil, nil, err
+	}
+
 	// Log credential format detection
 	switch v := azureCreds.(type) {
 	case map[string]string:
 		f.log.Info("Single service principal mode detected")
 	case []map[string]string:
 		f.log.Info("Multiple service principals mode detected", "servicePrincipalCount", len(v))
-	default:
-		return nil, nil, errors.New("invalid credential format")
 	}
 
 	if f.azureQuery == nil {
-		f.azureQuery = &AzureQuery{}
+		f.azureQuery = &AzureQuery{policy: f.policy}
 	}
 
 	return in, azureCreds, nil
@@ -199,13 +251,13 @@ func (f *Function) resolveSubscriptions(req *fnv1.RunFunctionRequest, in *v1beta
 		paved := fieldpath.Pave(functionContext)
 		value, err := paved.GetValue(strings.TrimPrefix(*in.SubscriptionsRef, "context."))
 		if err == nil && value != nil {
-			if arr, ok := value.([]interface{}); ok {
-				in.Subscriptions = make([]*string, len(arr))
-				for i, sub := range arr {
-					if strSub, ok := sub.(string); ok {
-						in.Subscriptions[i] = to.Ptr(strSub)
-					}
-				}
+			subscriptions, err := subscriptionsFromValue(*in.SubscriptionsRef, value)
+			if err != nil {
+				response.Fatal(rsp, err)
+				return err
+			}
+			if subscriptions != nil {
+				in.Subscriptions = subscriptions
 			}
 		}
 	default:
@@ -278,43 +330,52 @@ func (f *Function) getSubscriptionsFromStatus(req *fnv1.RunFunctionRequest, in *
 	paved := fieldpath.Pave(xrStatus)
 	value, err := paved.GetValue(strings.TrimPrefix(*in.SubscriptionsRef, "status."))
 	if err == nil && value != nil {
-		if arr, ok := value.([]interface{}); ok {
-			in.Subscriptions = make([]*string, len(arr))
-			for i, sub := range arr {
-				if strSub, ok := sub.(string); ok {
-					in.Subscriptions[i] = to.Ptr(strSub)
-				}
-			}
+		subscriptions, err := subscriptionsFromValue(*in.SubscriptionsRef, value)
+		if err != nil {
+			return err
+		}
+		if subscriptions != nil {
+			in.Subscriptions = subscriptions
 		}
 	}
 	return nil
 }
 
-// checkStatusTargetHasData checks if the status target has data.
-func (f *Function) checkStatusTargetHasData(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
+// checkTargetsHaveData checks if every target has data.
+func (f *Function) checkTargetsHaveData(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
 	xrStatus, _, err := f.getXRAndStatus(req)
 	if err != nil {
 		response.Fatal(rsp, err)
 		return true
 	}
+	contextMap := req.GetContext().AsMap()
 
-	statusField := strings.TrimPrefix(in.Target, "status.")
-	if hasData, _ := targetHasData(xrStatus, statusField); hasData {
-		f.log.Info("Target already has data, skipping query", "target", in.Target)
-		response.ConditionTrue(rsp, "FunctionSkip", "SkippedQuery").
-			WithMessage("Target already has data, skipped query to avoid throttling").
-			TargetCompositeAndClaim()
-		return true
+	for _, t := range inputTargets(in) {
+		var hasData bool
+		switch {
+		case strings.HasPrefix(t.Path, "status."):
+			hasData, _ = targetHasData(xrStatus, strings.TrimPrefix(t.Path, "status."))
+		case strings.HasPrefix(t.Path, "context."):
+			hasData, _ = targetHasData(contextMap, strings.TrimPrefix(t.Path, "context."))
+		}
+		if !hasData {
+			return false
+		}
 	}
-	return false
+
+	f.log.Info("Target already has data, skipping query", "target", in.Target, "targets", len(in.Targets))
+	response.ConditionTrue(rsp, skipCondition(in), "SkippedQuery").
+		WithMessage("Target already has data, skipped query to avoid throttling").
+		TargetCompositeAndClaim()
+	return true
 }
 
 // executeQuery executes the query.
-func (f *Function) executeQuery(ctx context.Context, azureCreds interface{}, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) (armresourcegraph.ClientResourcesResponse, error) {
+func (f *Function) executeQuery(ctx context.Context, req *fnv1.RunFunctionRequest, azureCreds interface{}, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) (armresourcegraph.ClientResourcesResponse, error) {
 	results, err := f.azureQuery.azQuery(ctx, azureCreds, in, f.log)
 	if err != nil {
-		response.Fatal(rsp, err)
 		f.log.Info("FAILURE: ", "failure", fmt.Sprint(err))
+		f.handleQueryError(req, in, rsp, err)
 		return armresourcegraph.ClientResourcesResponse{}, err
 	}
 
@@ -327,26 +388,128 @@ func (f *Function) executeQuery(ctx context.Context, azureCreds interface{}, in
 }
 
 // processResults processes the query results.
-func (f *Function) processResults(req *fnv1.RunFunctionRequest, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, rsp *fnv1.RunFunctionResponse) error {
-	switch {
-	case strings.HasPrefix(in.Target, "status."):
-		err := f.putQueryResultToStatus(req, rsp, in, results)
+func (f *Function) processResults(req *fnv1.RunFunctionRequest, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, info *queryInfo, baseline interface{}, rsp *fnv1.RunFunctionResponse) error {
+	rows, err := f.checkedRows(in, results.Data, rsp)
+	if err != nil {
+		return err
+	}
+
+	limits := f.resultLimits(in)
+	rows, warning, err := limits.limitRows(rows)
+	if errors.Is(err, errKeepPrevious) {
+		response.Warning(rsp, err)
+		f.keepContextTargets(req, in, rsp)
+		return nil
+	}
+	if err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+	if warning != "" {
+		response.Warning(rsp, errors.New(warning))
+	}
+
+	truncated, err := f.processTargets(req, in, rows, limits, rsp)
+	if err != nil {
+		return err
+	}
+	truncated = truncated || warning != ""
+	if err := f.writeDiff(req, in, baseline, rsp); err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+
+	return f.writeQueryMetadata(req, in, results, rows, truncated, info, rsp)
+}
+
+// writeQueryMetadata writes the metadata of the query that produced the rows.
+func (f *Function) writeQueryMetadata(req *fnv1.RunFunctionRequest, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, rows interface{}, truncated bool, info *queryInfo, rsp *fnv1.RunFunctionResponse) error {
+	metadata := withRefresh(req, f.queryMetadata(in, results, rows, truncated, info))
+	if in.Notify != nil {
+		metadata["contentHashes"] = targetContentHashes(in, rsp)
+	}
+	err := f.putQueryMetadata(rsp, in, metadata)
+	if err == nil {
+		err = recordWrittenStatus(req, rsp, metadataPath(in))
+	}
+	if err != nil {
+		err = errors.Wrap(err, "cannot write query metadata")
+		response.Fatal(rsp, err)
+		return err
+	}
+	return nil
+}
+
+// processTargets writes the rows to every target and reports whether any value
+// was truncated.
+func (f *Function) processTargets(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rows interface{}, limits resultLimits, rsp *fnv1.RunFunctionResponse) (bool, error) {
+	truncated := false
+	for _, t := range inputTargets(in) {
+		targetTruncated, err := f.processTarget(req, in, t, rows, limits, rsp)
 		if err != nil {
-			response.Fatal(rsp, err)
-			return err
+			return false, err
 		}
-	case strings.HasPrefix(in.Target, "context."):
-		err := putQueryResultToContext(req, rsp, in, results, f)
-		if err != nil {
-			response.Fatal(rsp, err)
-			return err
+		truncated = truncated || targetTruncated
+	}
+	return truncated, nil
+}
+
+// processTarget shapes the rows for a target, merges them with its existing
+// value and writes them, unless the result exceeds maxBytes and the previous
+// value is kept. It reports whether the value was truncated.
+func (f *Function) processTarget(req *fnv1.RunFunctionRequest, in *v1beta1.Input, t v1beta1.Target, rows interface{}, limits resultLimits, rsp *fnv1.RunFunctionResponse) (bool, error) {
+	// Reshape the rows before they are written to the target
+	data, warnings, err := shapeResult(t, rows)
+	if err == nil {
+		data, err = mergeIntoTarget(req, t, data)
+	}
+	warning := ""
+	if err == nil {
+		data, warning, err = limits.limitBytes(data)
+	}
+	if errors.Is(err, errKeepPrevious) {
+		response.Warning(rsp, errors.Wrapf(err, "target %s", t.Path))
+		// The context does not survive between reconciles, restore what the
+		// last query cached
+		if err := f.restoreContextTarget(req, in, t.Path, rsp); err != nil {
+			f.log.Debug("Cannot restore context target that keeps its previous value", "target", t.Path, "error", err)
 		}
+		return false, nil
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "target %s", t.Path)
+		response.Fatal(rsp, err)
+		return false, err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	for _, w := range warnings {
+		response.Warning(rsp, errors.Errorf("target %s: %s", t.Path, w))
+	}
+
+	err = f.putQueryResult(rsp, t.Path, data)
+	if err == nil {
+		err = recordWrittenStatus(req, rsp, t.Path)
+	}
+	if err != nil {
+		response.Fatal(rsp, err)
+		return false, err
+	}
+	return warning != "", nil
+}
+
+// putQueryResult writes the result to a status or context target.
+func (f *Function) putQueryResult(rsp *fnv1.RunFunctionResponse, target string, data interface{}) error {
+	switch {
+	case strings.HasPrefix(target, "status."):
+		return f.putQueryResultToStatus(rsp, target, data)
+	case strings.HasPrefix(target, "context."):
+		return putQueryResultToContext(rsp, target, data, f)
 	default:
 		// This should never happen because we check for valid targets earlier
-		response.Fatal(rsp, errors.Errorf("Unrecognized target field: %s", in.Target))
-		return errors.New("unrecognized target field")
+		return errors.Errorf("Unrecognized target field: %s", target)
 	}
-	return nil
 }
 
 func getCreds(req *fnv1.RunFunctionRequest) (interface{}, error) {
@@ -354,39 +517,27 @@ func getCreds(req *fnv1.RunFunctionRequest) (interface{}, error) {
 
 	if credsData, ok := rawCreds["azure-creds"]; ok {
 		credsData := credsData.GetCredentialData().GetData()
-		if credsJSON, ok := credsData["credentials"]; ok {
-			// Try to parse as array of service principals first
-			var servicePrincipals []map[string]string
-			if err := json.Unmarshal(credsJSON, &servicePrincipals); err == nil && len(servicePrincipals) > 0 {
-				return servicePrincipals, nil
-			}
-
-			// Fallback to single service principal format for backward compatibility
-			var singleCred map[string]string
-			if err := json.Unmarshal(credsJSON, &singleCred); err != nil {
-				return nil, errors.Wrap(err, "cannot parse json credentials")
-			}
-			return singleCred, nil
+		credsJSON, ok := credsData["credentials"]
+		if !ok || len(credsJSON) == 0 {
+			return nil, errors.New(`azure-creds credentials have no "credentials" key`)
 		}
-	} else {
-		return nil, errors.New("failed to get azure-creds credentials")
+		return parseCredentials(credsJSON)
 	}
 
-	return nil, nil
+	return nil, errors.New("failed to get azure-creds credentials")
 }
 
 // AzureQuery is a concrete implementation of the AzureQueryInterface
 // that interacts with Azure Resource Graph API.
-type AzureQuery struct{}
+type AzureQuery struct {
+	// policy restricts what queries may touch, nil allows everything
+	policy *Policy
+}
 
 // handleSingleServicePrincipal handles the case of a single service principal
 func (a *AzureQuery) handleSingleServicePrincipal(creds map[string]string, log logging.Logger) (map[string]string, []string, bool) {
-	allSubscriptionIDs := []string{}
-
 	// Extract subscription ID if present
-	if subID, exists := creds[SubscriptionID]; exists && subID != "" {
-		allSubscriptionIDs = append(allSubscriptionIDs, subID)
-	}
+	allSubscriptionIDs := credentialSubscriptions(creds)
 
 	log.Debug("Single service principal mode")
 	return creds, allSubscriptionIDs, false
@@ -401,42 +552,27 @@ func (a *AzureQuery) handleMultipleServicePrincipals(creds []map[string]string,
 	// Use round-robin selection with uint64 to avoid overflow conversion issues
 	index := atomic.AddUint64(&servicePrincipalCounter, 1) % uint64(len(creds))
 	selectedCreds := creds[index]
-	allSubscriptionIDs := []string{}
 
 	// Extract subscription IDs from all service principals
-	for _, cred := range creds {
-		if subID, exists := cred[SubscriptionID]; exists && subID != "" {
-			allSubscriptionIDs = append(allSubscriptionIDs, subID)
-		}
-	}
+	allSubscriptionIDs := credentialSubscriptions(creds)
 
 	log.Debug("Multiple service principals mode")
 	return selectedCreds, allSubscriptionIDs, true, nil
 }
 
 // setupQueryRequest configures the query request with subscriptions and management groups
-func (a *AzureQuery) setupQueryRequest(in *v1beta1.Input, allSubscriptionIDs []string, log logging.Logger) armresourcegraph.QueryRequest {
+func (a *AzureQuery) setupQueryRequest(in *v1beta1.Input, allSubscriptionIDs []string, log logging.Logger) (armresourcegraph.QueryRequest, error) {
 	queryRequest := armresourcegraph.QueryRequest{
 		Query: to.Ptr(in.Query),
 	}
 
-	// Handle subscriptions in the following priority:
-	// 1. Use Subscriptions field from Input if provided (from YAML composition)
-	// 2. Otherwise use subscriptionIDs from credentials if available (subscriptionId is optional)
-	// 3. If no subscriptions specified anywhere, the query will run against the tenant (all accessible subscriptions)
-	switch {
-	case len(in.Subscriptions) > 0:
-		queryRequest.Subscriptions = in.Subscriptions
-		log.Debug("Using subscriptions from input", "subscriptionCount", len(in.Subscriptions))
-	case len(allSubscriptionIDs) > 0:
-		// Convert string slice to []*string for the API
-		subscriptionPtrs := make([]*string, len(allSubscriptionIDs))
-		for i, subID := range allSubscriptionIDs {
-			subscriptionPtrs[i] = to.Ptr(subID)
-		}
-		queryRequest.Subscriptions = subscriptionPtrs
-		log.Debug("Using subscriptions from credentials", "subscriptionCount", len(allSubscriptionIDs))
-	default:
+	subscriptions, err := resolveScope(in.ScopePolicy, in.Subscriptions, allSubscriptionIDs, log)
+	if err != nil {
+		return armresourcegraph.QueryRequest{}, rejected(err)
+	}
+	if len(subscriptions) > 0 {
+		queryRequest.Subscriptions = toPtrs(subscriptions)
+	} else {
 		// No subscriptions specified in YAML or credentials - query will run against all accessible subscriptions in the tenant
 		log.Debug("No subscriptions specified in YAML or credentials - query will run against all accessible subscriptions in the tenant")
 	}
@@ -445,7 +581,7 @@ func (a *AzureQuery) setupQueryRequest(in *v1beta1.Input, allSubscriptionIDs []s
 		queryRequest.ManagementGroups = in.ManagementGroups
 	}
 
-	return queryRequest
+	return queryRequest, nil
 }
 
 // azQuery is a concrete implementation that interacts with Azure Resource Graph API.
@@ -453,11 +589,7 @@ func (a *AzureQuery) azQuery(ctx context.Context, azureCreds interface{}, in *v1
 	var selectedCreds map[string]string
 	var allSubscriptionIDs []string
 	var client *armresourcegraph.Client
-	identityType := v1beta1.IdentityTypeAzureServicePrincipalCredentials
-
-	if in.Identity != nil && in.Identity.Type != "" {
-		identityType = in.Identity.Type
-	}
+	identityType := identityTypeOf(in)
 
 	// Handle different credential formats and extract subscription IDs
 	switch v := azureCreds.(type) {
@@ -474,6 +606,16 @@ func (a *AzureQuery) azQuery(ctx context.Context, azureCreds interface{}, in *v1
 	default:
 		return armresourcegraph.ClientResourcesResponse{}, errors.New("invalid credential format")
 	}
+	recordClientID(ctx, selectedCreds[ClientID])
+
+	// Setup the query request and reject it before authenticating if the policy forbids it
+	queryRequest, err := a.setupQueryRequest(in, allSubscriptionIDs, log)
+	if err != nil {
+		return armresourcegraph.ClientResourcesResponse{}, err
+	}
+	if err := a.policy.Check(queryRequest); err != nil {
+		return armresourcegraph.ClientResourcesResponse{}, rejected(err)
+	}
 
 	switch identityType {
 	case v1beta1.IdentityTypeAzureServicePrincipalCredentials:
@@ -488,11 +630,10 @@ func (a *AzureQuery) azQuery(ctx context.Context, azureCreds interface{}, in *v1
 		if err != nil {
 			return armresourcegraph.ClientResourcesResponse{}, errors.Wrap(err, "failed to initialize workload identity provider")
 		}
+	default:
+		return armresourcegraph.ClientResourcesResponse{}, errors.Errorf("unsupported identity.type: %s", string(identityType))
 	}
 
-	// Setup the query request
-	queryRequest := a.setupQueryRequest(in, allSubscriptionIDs, log)
-
 	// Create the query request, Run the query and get the results.
 	results, err = client.Resources(ctx, queryRequest, nil)
 	if err != nil {
@@ -523,13 +664,14 @@ func (a *AzureQuery) initializeWorkloadIdentityProvider(azureCreds map[string]st
 	// Create Azure credential
 	log.Info("Initializing workload identity provider", "tokenFile", tokenFilePath)
 
+	options.ClientOptions = clientOptions(azureCreds)
 	cred, err := azidentity.NewWorkloadIdentityCredential(options)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to obtain workloadidentity credentials")
 	}
 
 	// Create and authorize a ResourceGraph client
-	client, err := armresourcegraph.NewClient(cred, nil)
+	client, err := armresourcegraph.NewClient(cred, armClientOptions(azureCreds))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create client")
 	}
@@ -546,13 +688,15 @@ func (a *AzureQuery) initializeClientSecretProvider(azureCreds map[string]string
 
 	// To configure DefaultAzureCredential to authenticate a user-assigned managed identity,
 	// set the environment variable AZURE_CLIENT_ID to the identity's client ID.
-	cred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
+	cred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, &azidentity.ClientSecretCredentialOptions{
+		ClientOptions: clientOptions(azureCreds),
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to obtain clientsecret credentials")
 	}
 
 	// Create and authorize a ResourceGraph client
-	client, err := armresourcegraph.NewClient(cred, nil)
+	client, err := armresourcegraph.NewClient(cred, armClientOptions(azureCreds))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create client")
 	}
@@ -645,37 +789,15 @@ func SetNestedKey(root map[string]interface{}, key string, value interface{}) er
 }
 
 // putQueryResultToStatus processes the query results to status
-func (f *Function) putQueryResultToStatus(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse) error {
-	xrStatus, dxr, err := f.getXRAndStatus(req)
+func (f *Function) putQueryResultToStatus(rsp *fnv1.RunFunctionResponse, target string, resultData interface{}) error {
+	// Start from the desired XR in the response so several targets compose
+	xrStatus, dxr, err := desiredXRAndStatus(rsp)
 	if err != nil {
 		return err
 	}
 
-	// Prepare the result data with timestamp if interval is configured
-	resultData := results.Data
-	if in.QueryIntervalMinutes != nil && *in.QueryIntervalMinutes > 0 {
-		if dataArray, ok := resultData.([]interface{}); ok {
-			// For array results (the intended structure), add lastQueryTime as special element
-			timestampElement := map[string]interface{}{
-				"lastQueryTime": time.Now().Format(time.RFC3339),
-			}
-			dataArray = append(dataArray, timestampElement)
-			resultData = dataArray
-			f.log.Debug("Added lastQueryTime element to array result", "target", in.Target, "queryIntervalMinutes", *in.QueryIntervalMinutes)
-		} else if dataMap, ok := resultData.(map[string]interface{}); ok {
-			// For map results (backwards compatibility), add lastQueryTime as field
-			dataMap["lastQueryTime"] = time.Now().Format(time.RFC3339)
-			f.log.Debug("Added lastQueryTime to map result", "target", in.Target, "queryIntervalMinutes", *in.QueryIntervalMinutes)
-		} else {
-			f.log.Debug("Result data is neither array nor map, cannot add lastQueryTime",
-				"target", in.Target,
-				"resultType", fmt.Sprintf("%T", resultData),
-				"queryIntervalMinutes", *in.QueryIntervalMinutes)
-		}
-	}
-
 	// Update the specific status field
-	statusField := strings.TrimPrefix(in.Target, "status.")
+	statusField := strings.TrimPrefix(target, "status.")
 	err = SetNestedKey(xrStatus, statusField, resultData)
 	if err != nil {
 		return errors.Wrapf(err, "cannot set status field %s to %v", statusField, resultData)
@@ -693,23 +815,60 @@ func (f *Function) putQueryResultToStatus(req *fnv1.RunFunctionRequest, rsp *fnv
 	return nil
 }
 
-func putQueryResultToContext(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, f *Function) error {
+// desiredXRAndStatus returns the desired XR already in the response, as set up
+// by propagateDesiredXR, together with its status.
+func desiredXRAndStatus(rsp *fnv1.RunFunctionResponse) (map[string]interface{}, *resource.Composite, error) {
+	dxr := &resource.Composite{
+		Resource:          composite.New(),
+		ConnectionDetails: rsp.GetDesired().GetComposite().GetConnectionDetails(),
+		// Keep the readiness, e.g. set by an expectation
+		Ready: compositeReady(rsp.GetDesired().GetComposite().GetReady()),
+	}
+	if dxr.ConnectionDetails == nil {
+		dxr.ConnectionDetails = make(resource.ConnectionDetails)
+	}
+	if err := resource.AsObject(rsp.GetDesired().GetComposite().GetResource(), dxr.Resource); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot get desired composite resource from response")
+	}
 
-	contextField := strings.TrimPrefix(in.Target, "context.")
-	data, err := structpb.NewValue(results.Data)
+	xrStatus := make(map[string]interface{})
+	if err := dxr.Resource.GetValueInto("status", &xrStatus); err != nil {
+		// No status yet
+		xrStatus = make(map[string]interface{})
+	}
+	return xrStatus, dxr, nil
+}
+
+// compositeReady converts the readiness of the desired XR in a response.
+func compositeReady(r fnv1.Ready) resource.Ready {
+	switch r {
+	case fnv1.Ready_READY_TRUE:
+		return resource.ReadyTrue
+	case fnv1.Ready_READY_FALSE:
+		return resource.ReadyFalse
+	default:
+		return resource.ReadyUnspecified
+	}
+}
+
+func putQueryResultToContext(rsp *fnv1.RunFunctionResponse, target string, resultData interface{}, f *Function) error {
+
+	contextField := strings.TrimPrefix(target, "context.")
+	data, err := structpb.NewValue(resultData)
 	if err != nil {
 		return errors.Wrap(err, "cannot convert results data to structpb.Value")
 	}
 
-	// Convert existing context into a map[string]interface{}
-	contextMap := req.GetContext().AsMap()
+	// Convert the context already in the response into a map[string]interface{}
+	// so several targets compose
+	contextMap := rsp.GetContext().AsMap()
 
 	err = SetNestedKey(contextMap, contextField, data.AsInterface())
 	if err != nil {
 		return errors.Wrap(err, "failed to update context key")
 	}
 
-	f.log.Debug("Updating Composition Pipeline Context", "key", contextField, "data", &results.Data)
+	f.log.Debug("Updating Composition Pipeline Context", "key", contextField, "data", resultData)
 
 	// Convert the updated context back into structpb.Struct
 	updatedContext, err := structpb.NewStruct(contextMap)
@@ -793,6 +952,15 @@ func (f *Function) propagateDesiredXR(req *fnv1.RunFunctionRequest, rsp *fnv1.Ru
 		return err
 	}
 
+	// Note the observed status carried forward, so later merge steps do not
+	// take it as a result
+	if !hasDesiredStatus(req) {
+		if err := recordCarriedStatus(req, rsp, xrStatus); err != nil {
+			response.Fatal(rsp, err)
+			return err
+		}
+	}
+
 	f.log.Info("Successfully propagated Desired XR")
 	return nil
 }
@@ -814,65 +982,114 @@ func (f *Function) isValidTarget(target string) bool {
 }
 
 // shouldSkipQuery checks if the query should be skipped.
-func (f *Function) shouldSkipQuery(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
-	// Check interval-based skipping first
-	if f.shouldSkipQueryDueToInterval(req, in, rsp) {
+func (f *Function) shouldSkipQuery(req *fnv1.RunFunctionRequest, in *v1beta1.Input, info *queryInfo, rsp *fnv1.RunFunctionResponse) bool {
+	// Operators may pause queries or request a refresh through annotations
+	if f.shouldSkipQueryDueToPause(req, in, rsp) {
 		return true
 	}
+	if f.refreshRequested(req, in) {
+		f.log.Info("Refresh requested, querying again", "annotation", RefreshAnnotation)
+		return false
+	}
 
-	// Determine if we should skip the query when target has data
-	var shouldSkipQueryWhenTargetHasData = false // Default to false to ensure continuous reconciliation
-	if in.SkipQueryWhenTargetHasData != nil {
-		shouldSkipQueryWhenTargetHasData = *in.SkipQueryWhenTargetHasData
+	// Data written by another query, scope or output options is stale
+	if f.queryChanged(req, in, info) {
+		f.log.Info("Query, scope or output options changed, querying again", "target", metadataPath(in))
+		return false
 	}
 
-	if !shouldSkipQueryWhenTargetHasData {
+	// Check schedule and interval-based skipping first
+	if f.shouldSkipQueryDueToSchedule(req, in, rsp) || f.shouldSkipQueryDueToInterval(req, in, rsp) {
+		return true
+	}
+
+	if !skipWhenHasData(in) {
 		return false
 	}
 
-	switch {
-	case strings.HasPrefix(in.Target, "status."):
-		return f.checkStatusTargetHasData(req, in, rsp)
-	case strings.HasPrefix(in.Target, "context."):
-		return f.checkContextTargetHasData(req, in, rsp)
+	return f.checkTargetsHaveData(req, in, rsp)
+}
+
+// skipWhenHasData reports whether the query is skipped when every target has
+// data. Default is false to ensure continuous reconciliation.
+func skipWhenHasData(in *v1beta1.Input) bool {
+	return in.SkipQueryWhenTargetHasData != nil && *in.SkipQueryWhenTargetHasData
+}
+
+// shouldSkipQueryDueToSchedule checks if the query should be skipped as it is
+// outside of the schedule windows. A query that never ran is not skipped.
+func (f *Function) shouldSkipQueryDueToSchedule(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
+	if len(in.Schedule) == 0 || inSchedule(in.Schedule, f.clock()) {
+		return false
 	}
 
-	return false
+	lastQueryTime, err := f.lastQueryTime(req, in)
+	if err != nil {
+		f.log.Debug("Cannot get lastQueryTime for schedule check", "error", err)
+		return false
+	}
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets for schedule check", "error", err)
+		return false
+	}
+
+	f.log.Info("Skipping query outside of the schedule", "target", metadataPath(in))
+	response.ConditionTrue(rsp, skipCondition(in), "OutsideSchedule").
+		WithMessage("Query skipped outside of the schedule windows").
+		TargetCompositeAndClaim()
+	f.setQueryTTL(req, in, rsp, lastQueryTime)
+	return true
 }
 
 // shouldSkipQueryDueToInterval checks if the query should be skipped due to interval limits.
 func (f *Function) shouldSkipQueryDueToInterval(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
-	if in.QueryIntervalMinutes == nil || *in.QueryIntervalMinutes <= 0 {
+	if !intervalSet(in) {
 		return false
 	}
+	interval := f.queryInterval(req, in)
 
-	// Only check intervals for status targets
-	if !strings.HasPrefix(in.Target, "status.") {
+	lastQueryTime, err := f.lastQueryTime(req, in)
+	if err != nil {
+		f.log.Debug("Cannot get lastQueryTime for interval check", "error", err)
+		return false
+	}
+	if f.clock().Sub(lastQueryTime) >= interval {
 		return false
 	}
 
-	targetData, err := f.getTargetData(req, in)
-	if err != nil {
+	// The context does not survive between reconciles, restore the context
+	// targets from the query metadata or query again
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets for interval check", "error", err)
 		return false
 	}
 
-	lastQueryTime, err := f.extractLastQueryTime(targetData)
-	if err != nil {
+	if !f.checkIntervalLimit(in, lastQueryTime, interval, rsp) {
 		return false
 	}
+	f.setQueryTTL(req, in, rsp, lastQueryTime)
+	return true
+}
 
-	return f.checkIntervalLimit(lastQueryTime, *in.QueryIntervalMinutes, in.Target, rsp)
+// queryInterval returns the query interval with the jitter of the observed XR.
+func (f *Function) queryInterval(req *fnv1.RunFunctionRequest, in *v1beta1.Input) time.Duration {
+	interval, _ := queryInterval(in)
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		return interval
+	}
+	return withJitter(interval, in.Jitter, string(oxr.Resource.GetUID()))
 }
 
 // getTargetData retrieves the current target data from XR status
-func (f *Function) getTargetData(req *fnv1.RunFunctionRequest, in *v1beta1.Input) (interface{}, error) {
+func (f *Function) getTargetData(req *fnv1.RunFunctionRequest, target string) (interface{}, error) {
 	xrStatus, _, err := f.getXRAndStatus(req)
 	if err != nil {
 		f.log.Debug("Cannot get XR status for interval check", "error", err)
 		return nil, err
 	}
 
-	statusField := strings.TrimPrefix(in.Target, "status.")
+	statusField := strings.TrimPrefix(target, "status.")
 	parts, err := ParseNestedKey(statusField)
 	if err != nil {
 		return nil, err
@@ -951,38 +1168,20 @@ func (f *Function) extractLastQueryTimeFromMap(dataMap map[string]interface{}) (
 }
 
 // checkIntervalLimit checks if the interval has elapsed and skips if needed
-func (f *Function) checkIntervalLimit(lastQueryTime time.Time, intervalMinutes int, target string, rsp *fnv1.RunFunctionResponse) bool {
-	now := time.Now()
-	elapsed := now.Sub(lastQueryTime)
-	intervalDuration := time.Duration(intervalMinutes) * time.Minute
+func (f *Function) checkIntervalLimit(in *v1beta1.Input, lastQueryTime time.Time, interval time.Duration, rsp *fnv1.RunFunctionResponse) bool {
+	elapsed := f.clock().Sub(lastQueryTime)
 
-	if elapsed < intervalDuration {
+	if elapsed < interval {
 		f.log.Info("Skipping query due to interval limit",
-			"target", target,
-			"intervalMinutes", intervalMinutes,
+			"target", metadataPath(in),
+			"interval", interval.String(),
 			"elapsedMinutes", elapsed.Minutes())
 
-		response.ConditionTrue(rsp, "FunctionSkip", "IntervalLimit").
-			WithMessage(fmt.Sprintf("Query skipped due to interval limit (%d minutes)", intervalMinutes)).
+		response.ConditionTrue(rsp, skipCondition(in), "IntervalLimit").
+			WithMessage(fmt.Sprintf("Query skipped due to interval limit (%s)", interval.Round(time.Second))).
 			TargetCompositeAndClaim()
 		return true
 	}
 
 	return false
 }
-
-// checkContextTargetHasData checks if the context target has data.
-func (f *Function) checkContextTargetHasData(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) bool {
-	contextMap := req.GetContext().AsMap()
-	contextField := strings.TrimPrefix(in.Target, "context.")
-	if hasData, _ := targetHasData(contextMap, contextField); hasData {
-		f.log.Info("Target already has data, skipping query", "target", in.Target)
-
-		// Set success condition and return
-		response.ConditionTrue(rsp, "FunctionSkip", "SkippedQuery").
-			WithMessage("Target already has data, skipped query to avoid throttling").
-			TargetCompositeAndClaim()
-		return true
-	}
-	return false
-}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"testing"
 	"time"
 
@@ -13,6 +12,7 @@ import (
 	"github.com/upbound/function-azresourcegraph/input/v1beta1"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/crossplane/function-sdk-go/errors"
 	"github.com/crossplane/function-sdk-go/logging"
@@ -33,10 +33,77 @@ func strPtr(s string) *string {
 	return &s
 }
 
+// The feature tests run the Function end to end with the helpers below.
+
+// queried is the result of running the query the feature tests use.
+var queried = &fnv1.Result{
+	Severity: fnv1.Severity_SEVERITY_NORMAL,
+	Message:  `Query: "Resources| count"`,
+	Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+}
+
+// testCredentials returns the service principal credentials of the azure-creds
+// step.
+func testCredentials() map[string]*fnv1.Credentials {
+	return azureCredentials(`{
+"clientId": "test-client-id",
+"clientSecret": "test-client-secret",
+"subscriptionId": "test-subscription-id",
+"tenantId": "test-tenant-id"
+}`)
+}
+
+// azureCredentials returns the azure-creds credentials of the function.
+func azureCredentials(credentials string) map[string]*fnv1.Credentials {
+	return map[string]*fnv1.Credentials{"azure-creds": {Source: &fnv1.Credentials_CredentialData{CredentialData: &fnv1.CredentialData{
+		Data: map[string][]byte{"credentials": []byte(credentials)},
+	}}}}
+}
+
+// mockRows returns a query that returns the rows.
+func mockRows(rows ...interface{}) *MockAzureQuery {
+	return &MockAzureQuery{
+		AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+			return armresourcegraph.ClientResourcesResponse{
+				QueryResponse: armresourcegraph.QueryResponse{Data: rows},
+			}, nil
+		},
+	}
+}
+
+// reconcile returns the request of a reconcile of the observed XR with the
+// Input, an empty context and the test credentials.
+func reconcile(input, xr string) *fnv1.RunFunctionRequest {
+	return &fnv1.RunFunctionRequest{
+		Meta:        &fnv1.RequestMeta{Tag: "hello"},
+		Input:       resource.MustStructJSON(input),
+		Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)}},
+		Context:     resource.MustStructJSON(`{}`),
+		Credentials: testCredentials(),
+	}
+}
+
+// desiredStatus returns the status of the desired XR, empty if it has none.
+func desiredStatus(rsp *fnv1.RunFunctionResponse) *structpb.Struct {
+	if status := rsp.GetDesired().GetComposite().GetResource().GetFields()["status"].GetStructValue(); status != nil {
+		return status
+	}
+	return &structpb.Struct{Fields: map[string]*structpb.Value{}}
+}
+
 func TestRunFunction(t *testing.T) {
 
 	var (
-		xr    = `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"count":2}}`
+		xr  = `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"count":2}}`
+		now = time.Now().Truncate(time.Second)
+		// azureCreds are the parsed creds, which the fingerprint covers
+		azureCreds = map[string]string{ClientID: "test-cliend-id", SubscriptionID: "test-subscription-id"}
+		// metadata is the query metadata written for the mock result to target
+		metadata = func(target string) string {
+			return `{"lastQueryTime":"` + now.Format(time.RFC3339) + `","queryHash":"` + queryHash("Resources| count") +
+				`","fingerprint":"` + fingerprint(&v1beta1.Input{Query: "Resources| count", Target: target}, azureCreds) +
+				`","rowCount":1,"totalRecords":1,"truncated":false}`
+		}
 		creds = &fnv1.CredentialData{
 			Data: map[string][]byte{
 				"credentials": []byte(`{
@@ -368,6 +435,7 @@ func TestRunFunction(t *testing.T) {
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["someField"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -759,6 +827,7 @@ func TestRunFunction(t *testing.T) {
 					},
 					Context: resource.MustStructJSON(
 						`{
+							"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]},
 							"azResourceGraphQueryResult":
 								{
 									"resource": "mock-resource"
@@ -835,6 +904,7 @@ func TestRunFunction(t *testing.T) {
 							Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["nestedField"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -911,6 +981,7 @@ func TestRunFunction(t *testing.T) {
 						},
 					},
 					Context: resource.MustStructJSON(`{
+						"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["nestedField"]},
 						"azResourceGraphQueryResult": {
 							"resource": "existing-data"
 						}
@@ -992,6 +1063,7 @@ func TestRunFunction(t *testing.T) {
 						},
 					},
 					Context: resource.MustStructJSON(`{
+						"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]},
 						"nestedField": {
 							"azResourceGraphQueryResult": {
 								"resource": "existing-data"
@@ -1133,6 +1205,7 @@ func TestRunFunction(t *testing.T) {
 						},
 					},
 					Context: resource.MustStructJSON(`{
+						"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]},
 						"azResourceGraphQueryResult":
 							{
 								"resource": "mock-resource"
@@ -1395,6 +1468,7 @@ func TestRunFunction(t *testing.T) {
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -1463,6 +1537,7 @@ func TestRunFunction(t *testing.T) {
 							Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -1719,6 +1794,7 @@ func TestRunFunction(t *testing.T) {
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["subscriptionsList"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -1788,6 +1864,7 @@ func TestRunFunction(t *testing.T) {
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["nested"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -1858,6 +1935,7 @@ func TestRunFunction(t *testing.T) {
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["complex.field.with.dots"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -2119,7 +2197,7 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(10 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:   "FunctionSuccess",
@@ -2146,7 +2224,8 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "mock-resource"
-									}
+									},
+									"azResourceGraphQueryResultMetadata": ` + metadata("status.azResourceGraphQueryResult") + `
 								}}`),
 						},
 					},
@@ -2177,7 +2256,7 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "existing-data",
-										"lastQueryTime": "` + time.Now().Add(-5*time.Minute).Format(time.RFC3339) + `"
+										"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"
 									}
 								}}`),
 						},
@@ -2191,11 +2270,11 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(5 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:    "FunctionSkip",
-							Message: strPtr("Query skipped due to interval limit (10 minutes)"),
+							Message: strPtr("Query skipped due to interval limit (10m0s)"),
 							Status:  fnv1.Status_STATUS_CONDITION_TRUE,
 							Reason:  "IntervalLimit",
 							Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
@@ -2207,6 +2286,7 @@ func TestRunFunction(t *testing.T) {
 							Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
 						},
 					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult"]}}`),
 					Desired: &fnv1.State{
 						Composite: &fnv1.Resource{
 							Resource: resource.MustStructJSON(`{
@@ -2218,7 +2298,7 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "existing-data",
-										"lastQueryTime": "` + time.Now().Add(-5*time.Minute).Format(time.RFC3339) + `"
+										"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"
 									}
 								}}`),
 						},
@@ -2250,7 +2330,7 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "existing-data",
-										"lastQueryTime": "` + time.Now().Add(-15*time.Minute).Format(time.RFC3339) + `"
+										"lastQueryTime": "` + now.Add(-15*time.Minute).Format(time.RFC3339) + `"
 									}
 								}}`),
 						},
@@ -2264,7 +2344,7 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(10 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:   "FunctionSuccess",
@@ -2291,7 +2371,8 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "mock-resource"
-									}
+									},
+									"azResourceGraphQueryResultMetadata": ` + metadata("status.azResourceGraphQueryResult") + `
 								}}`),
 						},
 					},
@@ -2336,7 +2417,7 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(10 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:   "FunctionSuccess",
@@ -2363,15 +2444,16 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "mock-resource"
-									}
+									},
+									"azResourceGraphQueryResultMetadata": ` + metadata("status.azResourceGraphQueryResult") + `
 								}}`),
 						},
 					},
 				},
 			},
 		},
-		"ShouldIgnoreIntervalForContextTargets": {
-			reason: "The Function should ignore interval limits for context targets",
+		"ShouldKeepIntervalStateInStatusForContextTargets": {
+			reason: "The Function should keep the interval state and a cache of context targets in the XR status",
 			args: args{
 				ctx: context.Background(),
 				req: &fnv1.RunFunctionRequest{
@@ -2397,7 +2479,7 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(10 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:   "FunctionSuccess",
@@ -2425,6 +2507,21 @@ func TestRunFunction(t *testing.T) {
 								"kind": "XR",
 								"metadata": {
 									"name": "cool-xr"
+								},
+								"status": {
+									"azResourceGraphQueryResultMetadata": {
+										"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+										"queryHash": "` + queryHash("Resources| count") + `",
+										"fingerprint": "` + fingerprint(&v1beta1.Input{Query: "Resources| count", Target: "context.azResourceGraphQueryResult"}, azureCreds) + `",
+										"rowCount": 1,
+										"totalRecords": 1,
+										"truncated": false,
+										"cache": {
+											"context.azResourceGraphQueryResult": {
+												"resource": "mock-resource"
+											}
+										}
+									}
 								}
 							}`),
 						},
@@ -2456,7 +2553,7 @@ func TestRunFunction(t *testing.T) {
 								"status": {
 									"azResourceGraphQueryResult": {
 										"resource": "existing-data",
-										"lastQueryTime": "` + time.Now().Add(-1*time.Minute).Format(time.RFC3339) + `"
+										"lastQueryTime": "` + now.Add(-1*time.Minute).Format(time.RFC3339) + `"
 									}
 								}}`),
 						},
@@ -2504,8 +2601,8 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 		},
-		"ShouldWriteLastQueryTimeForStatusTarget": {
-			reason: "The Function should write lastQueryTime when queryIntervalMinutes is set for status targets",
+		"ShouldWriteMetadataForStatusTarget": {
+			reason: "The Function should write the query metadata next to the status target when queryIntervalMinutes is set",
 			args: args{
 				ctx: context.Background(),
 				req: &fnv1.RunFunctionRequest{
@@ -2531,7 +2628,7 @@ func TestRunFunction(t *testing.T) {
 			},
 			want: want{
 				rsp: &fnv1.RunFunctionResponse{
-					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(10 * time.Minute)},
 					Conditions: []*fnv1.Condition{
 						{
 							Type:   "FunctionSuccess",
@@ -2557,9 +2654,9 @@ func TestRunFunction(t *testing.T) {
 								},
 								"status": {
 									"azResourceGraphQueryResult": {
-										"resource": "mock-resource",
-										"lastQueryTime": "2024-01-01T12:00:00Z"
-									}
+										"resource": "mock-resource"
+									},
+									"azResourceGraphQueryResultMetadata": ` + metadata("status.azResourceGraphQueryResult") + `
 								}}`),
 						},
 					},
@@ -2567,7 +2664,7 @@ func TestRunFunction(t *testing.T) {
 			},
 		},
 		"ShouldExecuteQueryWithDifferentTargetName": {
-			reason: "The Function should write lastQueryTime to any status target name when interval is set",
+			reason: "The Function should write the query metadata next to any status target name when interval is set",
 			args: args{
 				ctx: context.Background(),
 				req: &fnv1.RunFunctionRequest{
@@ -2592,12 +2689,44 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 			want: want{
-				// This test will use special validation
-				rsp: nil,
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(5 * time.Minute)},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:   "FunctionSuccess",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Success",
+							Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_NORMAL,
+							Message:  `Query: "Resources| count"`,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "example.org/v1",
+								"kind": "XR",
+								"metadata": {
+									"name": "cool-xr"
+								},
+								"status": {
+									"vmData": {
+										"resource": "mock-resource"
+									},
+									"vmDataMetadata": ` + metadata("status.vmData") + `
+								}}`),
+						},
+					},
+				},
 			},
 		},
-		"ShouldNotWriteLastQueryTimeForContextTarget": {
-			reason: "The Function should not write lastQueryTime for context targets",
+		"ShouldWriteMetadataToMetadataTarget": {
+			reason: "The Function should write the query metadata to metadataTarget instead of next to the target",
 			args: args{
 				ctx: context.Background(),
 				req: &fnv1.RunFunctionRequest{
@@ -2607,7 +2736,7 @@ func TestRunFunction(t *testing.T) {
 						"kind": "Input",
 						"query": "Resources| count",
 						"target": "context.azResourceGraphQueryResult",
-						"queryIntervalMinutes": 10
+						"metadataTarget": "status.[azure.query]"
 					}`),
 					Observed: &fnv1.State{
 						Composite: &fnv1.Resource{
@@ -2651,6 +2780,9 @@ func TestRunFunction(t *testing.T) {
 								"kind": "XR",
 								"metadata": {
 									"name": "cool-xr"
+								},
+								"status": {
+									"azure.query": ` + metadata("context.azResourceGraphQueryResult") + `
 								}
 							}`),
 						},
@@ -2718,8 +2850,8 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 		},
-		"ShouldAddTimestampToMapResultsOnly": {
-			reason: "The Function should only add lastQueryTime to map results (backwards compatibility)",
+		"ShouldSkipQueryWhenWithinIntervalFromMetadata": {
+			reason: "The Function should read lastQueryTime from the query metadata",
 			args: args{
 				ctx: context.Background(),
 				req: &fnv1.RunFunctionRequest{
@@ -2733,7 +2865,18 @@ func TestRunFunction(t *testing.T) {
 					}`),
 					Observed: &fnv1.State{
 						Composite: &fnv1.Resource{
-							Resource: resource.MustStructJSON(xr),
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "example.org/v1",
+								"kind": "XR",
+								"metadata": {
+									"name": "cool-xr"
+								},
+								"status": {
+									"azResourceGraphQueryResult": [{"resource": "existing-data"}],
+									"azResourceGraphQueryResultMetadata": {
+										"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"
+									}
+								}}`),
 						},
 					},
 					Credentials: map[string]*fnv1.Credentials{
@@ -2744,9 +2887,41 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 			want: want{
-				// This test verifies backwards compatibility - the mock returns a map
-				// so lastQueryTime should be added as a sibling field, not wrapped
-				rsp: nil, // Will be validated specially
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(5 * time.Minute)},
+					Conditions: []*fnv1.Condition{
+						{
+							Type:    "FunctionSkip",
+							Message: strPtr("Query skipped due to interval limit (10m0s)"),
+							Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason:  "IntervalLimit",
+							Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+						},
+						{
+							Type:   "FunctionSuccess",
+							Status: fnv1.Status_STATUS_CONDITION_TRUE,
+							Reason: "Success",
+							Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+						},
+					},
+					Context: resource.MustStructJSON(`{"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["azResourceGraphQueryResult", "azResourceGraphQueryResultMetadata"]}}`),
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "example.org/v1",
+								"kind": "XR",
+								"metadata": {
+									"name": "cool-xr"
+								},
+								"status": {
+									"azResourceGraphQueryResult": [{"resource": "existing-data"}],
+									"azResourceGraphQueryResultMetadata": {
+										"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"
+									}
+								}}`),
+						},
+					},
+				},
 			},
 		},
 	}
@@ -2771,29 +2946,12 @@ func TestRunFunction(t *testing.T) {
 			f := &Function{
 				azureQuery: mockQuery,
 				log:        logging.NewNopLogger(),
+				now:        func() time.Time { return now },
 			}
 			rsp, err := f.RunFunction(tc.args.ctx, tc.args.req)
 
-			// Special handling for timestamp tests
-			if name == "ShouldWriteLastQueryTimeForStatusTarget" || name == "ShouldExecuteQueryWithDifferentTargetName" || name == "ShouldAddTimestampToMapResultsOnly" || name == "ShouldExecuteQueryWhenNoLastQueryTime" || name == "ShouldExecuteQueryWhenInvalidTimestamp" || name == "ShouldExecuteQueryWhenIntervalExpired" {
-				// Verify the structure and validate timestamp
-				if err := validateLastQueryTimeInResponse(rsp, name); err != nil {
-					t.Errorf("%s\n%v", tc.reason, err)
-					return
-				}
-
-				// Additional validation for backwards compatibility test
-				if name == "ShouldAddTimestampToMapResultsOnly" {
-					if err := validateBackwardsCompatibility(rsp); err != nil {
-						t.Errorf("%s\nBackwards compatibility check failed: %v", tc.reason, err)
-						return
-					}
-				}
-				// Don't compare exact response for timestamp tests
-			} else {
-				if diff := cmp.Diff(tc.want.rsp, rsp, protocmp.Transform()); diff != "" {
-					t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
-				}
+			if diff := cmp.Diff(tc.want.rsp, rsp, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
 			}
 
 			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
@@ -3066,99 +3224,3 @@ func TestIdentityType(t *testing.T) {
 		})
 	}
 }
-
-func validateLastQueryTimeInResponse(rsp *fnv1.RunFunctionResponse, testName string) error {
-	if rsp.GetDesired() == nil || rsp.GetDesired().GetComposite() == nil {
-		return fmt.Errorf("missing desired composite resource")
-	}
-
-	// Determine which field to check based on test name
-	targetFieldName := "azResourceGraphQueryResult"
-	if testName == "ShouldExecuteQueryWithDifferentTargetName" {
-		targetFieldName = "vmData"
-	}
-
-	// Get status from the resource
-	statusValue, exists := rsp.GetDesired().GetComposite().GetResource().GetFields()["status"]
-	if !exists {
-		return fmt.Errorf("missing status field")
-	}
-
-	status := statusValue.GetStructValue().AsMap()
-
-	// Get the target data directly
-	targetData, ok := status[targetFieldName]
-	if !ok {
-		return fmt.Errorf("missing %s in status", targetFieldName)
-	}
-
-	queryResult, ok := targetData.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("query result is not a map")
-	}
-
-	// Check that resource field exists
-	if _, exists := queryResult["resource"]; !exists {
-		return fmt.Errorf("missing resource field in query result")
-	}
-
-	// Check that lastQueryTime exists and is a valid RFC3339 timestamp
-	lastQueryTimeRaw, exists := queryResult["lastQueryTime"]
-	if !exists {
-		return fmt.Errorf("missing lastQueryTime field")
-	}
-
-	lastQueryTimeStr, ok := lastQueryTimeRaw.(string)
-	if !ok {
-		return fmt.Errorf("lastQueryTime is not a string")
-	}
-
-	// Validate timestamp format
-	if _, err := time.Parse(time.RFC3339, lastQueryTimeStr); err != nil {
-		return fmt.Errorf("invalid lastQueryTime format: %w", err)
-	}
-
-	return nil
-}
-
-func validateBackwardsCompatibility(rsp *fnv1.RunFunctionResponse) error {
-	if rsp.GetDesired() == nil || rsp.GetDesired().GetComposite() == nil {
-		return fmt.Errorf("missing desired composite resource")
-	}
-
-	// Get status from the resource
-	statusValue, exists := rsp.GetDesired().GetComposite().GetResource().GetFields()["status"]
-	if !exists {
-		return fmt.Errorf("missing status field")
-	}
-
-	status := statusValue.GetStructValue().AsMap()
-
-	// Get the query result
-	targetData, ok := status["azResourceGraphQueryResult"]
-	if !ok {
-		return fmt.Errorf("missing azResourceGraphQueryResult in status")
-	}
-
-	queryResult, ok := targetData.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("query result is not a map")
-	}
-
-	// Verify backwards compatibility - the original "resource" field should be preserved at the top level
-	if _, exists := queryResult["resource"]; !exists {
-		return fmt.Errorf("original 'resource' field not preserved - backwards compatibility broken")
-	}
-
-	// Verify lastQueryTime is added as a sibling, not wrapped
-	if _, exists := queryResult["lastQueryTime"]; !exists {
-		return fmt.Errorf("lastQueryTime not added as sibling field")
-	}
-
-	// Verify there's no "data" wrapper that would break compatibility
-	if _, exists := queryResult["data"]; exists {
-		return fmt.Errorf("found 'data' wrapper field - this breaks backwards compatibility")
-	}
-
-	return nil
-}
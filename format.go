@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// formatOutput shapes the rows according to the output format. It returns
+// warnings for results that are valid but probably not what was intended,
+// such as several rows for the first and scalar formats.
+func formatOutput(format v1beta1.OutputFormat, column, keyBy string, data interface{}) (interface{}, []string, error) {
+	if format == "" || format == v1beta1.OutputFormatList {
+		return data, nil, nil
+	}
+
+	rows, err := toRows("outputFormat "+string(format), data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	if len(rows) > 1 && (format == v1beta1.OutputFormatFirst || format == v1beta1.OutputFormatScalar) {
+		warnings = append(warnings, fmt.Sprintf("query returned %d rows, outputFormat %s uses the first one", len(rows), format))
+	}
+
+	shaped, err := shapeRows(format, column, keyBy, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return shaped, warnings, nil
+}
+
+// shapeRows builds the value of a non-list output format from the rows.
+func shapeRows(format v1beta1.OutputFormat, column, keyBy string, rows []map[string]interface{}) (interface{}, error) {
+	switch format {
+	case v1beta1.OutputFormatFirst:
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		return rows[0], nil
+	case v1beta1.OutputFormatScalar:
+		return scalarOutput(rows, column)
+	case v1beta1.OutputFormatMap:
+		return mapOutput(rows, keyBy)
+	case v1beta1.OutputFormatTable:
+		return tableOutput(rows), nil
+	default:
+		return nil, errors.Errorf("unsupported outputFormat: %s", string(format))
+	}
+}
+
+// toRows asserts that the data is an array of objects. what names the option
+// that needs rows in errors.
+func toRows(what string, data interface{}) ([]map[string]interface{}, error) {
+	if data == nil {
+		return []map[string]interface{}{}, nil
+	}
+	arr, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s requires an array of rows, got %T", what, data)
+	}
+	rows := make([]map[string]interface{}, len(arr))
+	for i, item := range arr {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s requires rows to be objects, row %d is %T", what, i, item)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// scalarOutput returns the value of the column in the first row. The column
+// may be omitted when the first row has exactly one column.
+func scalarOutput(rows []map[string]interface{}, column string) (interface{}, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	row := rows[0]
+	if column == "" {
+		if len(row) != 1 {
+			return nil, errors.Errorf("outputFormat scalar requires column when rows have %d columns", len(row))
+		}
+		for _, v := range row {
+			return v, nil
+		}
+	}
+	v, ok := row[column]
+	if !ok {
+		return nil, errors.Errorf("outputFormat scalar: column %q not found in the first row", column)
+	}
+	return v, nil
+}
+
+// mapOutput keys the rows by the value of the keyBy column. A later row with
+// the same key replaces an earlier one.
+func mapOutput(rows []map[string]interface{}, keyBy string) (interface{}, error) {
+	if keyBy == "" {
+		return nil, errors.New("outputFormat map requires keyBy")
+	}
+	out := make(map[string]interface{}, len(rows))
+	for i, row := range rows {
+		key, ok := row[keyBy]
+		if !ok || key == nil {
+			return nil, errors.Errorf("outputFormat map: row %d has no value for keyBy column %q", i, keyBy)
+		}
+		switch k := key.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, errors.Errorf("outputFormat map: row %d keyBy column %q is not a scalar", i, keyBy)
+		case float64:
+			// Avoid exponent notation for large numeric keys
+			out[strconv.FormatFloat(k, 'f', -1, 64)] = row
+		default:
+			out[fmt.Sprint(k)] = row
+		}
+	}
+	return out, nil
+}
+
+// tableOutput returns the column names, sorted as JSON objects are unordered,
+// and the row values in the same order. Missing values are null.
+func tableOutput(rows []map[string]interface{}) map[string]interface{} {
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, row := range rows {
+		for c := range row {
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	values := make([]interface{}, len(rows))
+	for i, row := range rows {
+		v := make([]interface{}, len(columns))
+		for j, c := range columns {
+			v[j] = row[c]
+		}
+		values[i] = v
+	}
+
+	cols := make([]interface{}, len(columns))
+	for i, c := range columns {
+		cols[i] = c
+	}
+	return map[string]interface{}{"columns": cols, "rows": values}
+}
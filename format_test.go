@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+)
+
+func TestFormatOutput(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"name": "vnet-a", "id": "/a", "location": "westeurope"},
+		map[string]interface{}{"name": "vnet-b", "id": "/b"},
+	}
+	count := []interface{}{
+		map[string]interface{}{"count_": float64(2)},
+	}
+
+	type args struct {
+		format v1beta1.OutputFormat
+		column string
+		keyBy  string
+		data   interface{}
+	}
+	type want struct {
+		data     interface{}
+		warnings []string
+		err      string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"ListDefault": {
+			reason: "The default list format should return the data unchanged",
+			args:   args{data: rows},
+			want:   want{data: rows},
+		},
+		"First": {
+			reason: "The first format should return the first row and warn about the others",
+			args:   args{format: v1beta1.OutputFormatFirst, data: rows},
+			want: want{
+				data:     rows[0],
+				warnings: []string{"query returned 2 rows, outputFormat first uses the first one"},
+			},
+		},
+		"FirstNoRows": {
+			reason: "The first format should return null without rows",
+			args:   args{format: v1beta1.OutputFormatFirst, data: []interface{}{}},
+			want:   want{data: nil},
+		},
+		"ScalarSingleColumn": {
+			reason: "The scalar format should not need a column when rows have a single column",
+			args:   args{format: v1beta1.OutputFormatScalar, data: count},
+			want:   want{data: float64(2)},
+		},
+		"ScalarColumn": {
+			reason: "The scalar format should return the column of the first row",
+			args:   args{format: v1beta1.OutputFormatScalar, column: "id", data: rows[:1]},
+			want:   want{data: "/a"},
+		},
+		"ScalarNoRows": {
+			reason: "The scalar format should return null without rows",
+			args:   args{format: v1beta1.OutputFormatScalar, column: "id", data: []interface{}{}},
+			want:   want{data: nil},
+		},
+		"ScalarAmbiguousColumn": {
+			reason: "The scalar format should require a column when rows have several columns",
+			args:   args{format: v1beta1.OutputFormatScalar, data: rows[:1]},
+			want:   want{err: "outputFormat scalar requires column when rows have 3 columns"},
+		},
+		"ScalarMissingColumn": {
+			reason: "The scalar format should report a missing column",
+			args:   args{format: v1beta1.OutputFormatScalar, column: "sku", data: rows[:1]},
+			want:   want{err: `outputFormat scalar: column "sku" not found in the first row`},
+		},
+		"Map": {
+			reason: "The map format should key the rows by the keyBy column",
+			args:   args{format: v1beta1.OutputFormatMap, keyBy: "name", data: rows},
+			want: want{data: map[string]interface{}{
+				"vnet-a": rows[0],
+				"vnet-b": rows[1],
+			}},
+		},
+		"MapNumericKey": {
+			reason: "The map format should render numeric keys without exponent",
+			args:   args{format: v1beta1.OutputFormatMap, keyBy: "count_", data: []interface{}{map[string]interface{}{"count_": float64(1234567)}}},
+			want: want{data: map[string]interface{}{
+				"1234567": map[string]interface{}{"count_": float64(1234567)},
+			}},
+		},
+		"MapMissingKey": {
+			reason: "The map format should report rows without the keyBy column",
+			args:   args{format: v1beta1.OutputFormatMap, keyBy: "location", data: rows},
+			want:   want{err: `outputFormat map: row 1 has no value for keyBy column "location"`},
+		},
+		"MapRequiresKeyBy": {
+			reason: "The map format should require keyBy",
+			args:   args{format: v1beta1.OutputFormatMap, data: rows},
+			want:   want{err: "outputFormat map requires keyBy"},
+		},
+		"Table": {
+			reason: "The table format should return sorted columns and row values, with null for missing values",
+			args:   args{format: v1beta1.OutputFormatTable, data: rows},
+			want: want{data: map[string]interface{}{
+				"columns": []interface{}{"id", "location", "name"},
+				"rows": []interface{}{
+					[]interface{}{"/a", "westeurope", "vnet-a"},
+					[]interface{}{"/b", nil, "vnet-b"},
+				},
+			}},
+		},
+		"NotRows": {
+			reason: "Formats other than list should require an array of rows, e.g. after a transform",
+			args:   args{format: v1beta1.OutputFormatFirst, data: map[string]interface{}{"a": "b"}},
+			want:   want{err: "outputFormat first requires an array of rows, got map[string]interface {}"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, warnings, err := formatOutput(tc.args.format, tc.args.column, tc.args.keyBy, tc.args.data)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\nformatOutput(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.data, got); diff != "" {
+				t.Errorf("%s\nformatOutput(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.warnings, warnings); diff != "" {
+				t.Errorf("%s\nformatOutput(...): -want warnings, +got warnings:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
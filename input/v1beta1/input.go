@@ -44,8 +44,115 @@ type Input struct {
 	// +optional
 	SubscriptionsRef *string `json:"subscriptionsRef,omitempty"`
 
-	// Target where to store the Query Result
-	Target string `json:"target"`
+	// ScopePolicy controls how Subscriptions are combined with the subscriptions
+	// carried by the credentials. override uses the Input subscriptions when set
+	// and the credential subscriptions otherwise, union uses both and intersect
+	// restricts the Input subscriptions to those in the credentials.
+	// Default is override
+	// +kubebuilder:validation:Enum=override;union;intersect
+	// +optional
+	ScopePolicy ScopePolicy `json:"scopePolicy,omitempty"`
+
+	// AllowTenantScope controls whether the query may run against the whole
+	// tenant when neither the Input nor the credentials carry a subscription and
+	// no management groups are set. Defaults to the function-level
+	// --require-explicit-scope flag, which allows tenant-wide queries unless set
+	// +optional
+	AllowTenantScope *bool `json:"allowTenantScope,omitempty"`
+
+	// Target where to store the Query Result. Either Target or Targets is required
+	// +optional
+	Target string `json:"target,omitempty"`
+
+	// Targets store the Query Result in several locations, each with its own
+	// projection and shape, from a single query. Written after Target
+	// +optional
+	Targets []Target `json:"targets,omitempty"`
+
+	// Transform reshapes the query result before it is written to the Target
+	// +optional
+	Transform *Transform `json:"transform,omitempty"`
+
+	// OutputFormat controls the shape of the value written to the Target.
+	// list writes the rows as an array, first writes the first row, scalar
+	// writes the value of Column in the first row, map writes the rows keyed by
+	// the KeyBy column and table writes the column names and the row values.
+	// first and scalar write null when the query returns no rows.
+	// Applied after Transform. Default is list
+	// +kubebuilder:validation:Enum=list;first;scalar;map;table
+	// +optional
+	OutputFormat OutputFormat `json:"outputFormat,omitempty"`
+
+	// Column whose value is written by the scalar OutputFormat. May be omitted
+	// when the rows have a single column, e.g. for '| count'
+	// +optional
+	Column string `json:"column,omitempty"`
+
+	// KeyBy is the column whose values key the map OutputFormat, e.g. id or name
+	// +optional
+	KeyBy string `json:"keyBy,omitempty"`
+
+	// MergeStrategy controls how the result is combined with the value already
+	// at the Target. Default is replace
+	// +kubebuilder:validation:Enum=replace;deepMerge;append;upsertByKey
+	// +optional
+	MergeStrategy MergeStrategy `json:"mergeStrategy,omitempty"`
+
+	// MergeKey is the column identifying rows for the upsertByKey MergeStrategy, e.g. id
+	// +optional
+	MergeKey string `json:"mergeKey,omitempty"`
+
+	// DedupeBy collapses query result rows with the same values in these
+	// columns into the first of them, e.g. [id]. Applied before SortBy
+	// +optional
+	DedupeBy []string `json:"dedupeBy,omitempty"`
+
+	// SortBy orders the query result rows by these columns, each optionally
+	// followed by asc or desc, e.g. ['location', 'name desc']. Applied before
+	// the rows are limited, selected, transformed and formatted for the targets
+	// +optional
+	SortBy []string `json:"sortBy,omitempty"`
+
+	// MaxRows limits the number of query result rows written to the targets.
+	// Defaults to the function-level --max-rows flag. 0 disables the limit
+	// +optional
+	MaxRows *int `json:"maxRows,omitempty"`
+
+	// MaxBytes limits the JSON size of the value written to each target.
+	// Defaults to the function-level --max-bytes flag. 0 disables the limit
+	// +optional
+	MaxBytes *int `json:"maxBytes,omitempty"`
+
+	// OnLimitExceeded controls what happens when MaxRows or MaxBytes is exceeded.
+	// truncate drops the trailing rows and sets truncated in the query
+	// metadata, fail reports a fatal result and keepPrevious leaves the targets
+	// untouched. A Warning is emitted in every case. Default is truncate
+	// +kubebuilder:validation:Enum=truncate;fail;keepPrevious
+	// +optional
+	OnLimitExceeded LimitAction `json:"onLimitExceeded,omitempty"`
+
+	// OnError controls what happens when the query fails. fatal reports a
+	// fatal result and stops the pipeline, warn emits a Warning and ignore
+	// only logs the error. With warn and ignore the targets keep their data,
+	// and the error is recorded in the query metadata and the FunctionSuccess
+	// condition. Default is fatal
+	// +kubebuilder:validation:Enum=fatal;warn;ignore
+	// +optional
+	OnError ErrorAction `json:"onError,omitempty"`
+
+	// OnEmpty controls what happens when the query returns no rows. write
+	// writes the empty result, keepPrevious keeps the data of the targets,
+	// warn keeps it and emits a Warning and fatal reports a fatal result. A
+	// kept value is marked as stale in the query metadata. Default is write
+	// +kubebuilder:validation:Enum=write;keepPrevious;warn;fatal
+	// +optional
+	OnEmpty EmptyAction `json:"onEmpty,omitempty"`
+
+	// Expectations the query result rows must meet, checked after DedupeBy
+	// and before MaxRows limits the rows. A failed expectation is reported as
+	// its outcome asks
+	// +optional
+	Expectations []Expectation `json:"expectations,omitempty"`
 
 	// SkipQueryWhenTargetHasData controls whether to skip the query when the target already has data
 	// Default is false to ensure continuous reconciliation
@@ -55,12 +162,254 @@ type Input struct {
 	// QueryIntervalMinutes specifies the minimum interval between queries in minutes
 	// Used to prevent throttling and handle partial data scenarios
 	// Default is 0 (no interval limiting)
+	// Superseded by QueryInterval
+	// +kubebuilder:validation:Minimum=0
 	// +optional
 	QueryIntervalMinutes *int `json:"queryIntervalMinutes,omitempty"`
 
+	// QueryInterval specifies the minimum interval between queries as a Go
+	// duration, e.g. 90s or 1h30m. Takes the place of QueryIntervalMinutes,
+	// only one of them may be set
+	// +optional
+	QueryInterval string `json:"queryInterval,omitempty"`
+
+	// Jitter lengthens the query interval by up to this percentage, so XRs
+	// created at the same time do not query at the same moment. The share is
+	// derived from the XR UID and stays the same for every reconcile
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Jitter *int `json:"jitter,omitempty"`
+
+	// Schedule restricts queries to the time windows listed. Outside of them
+	// the query is skipped and the targets keep their data. A query still runs
+	// if there was none before
+	// +optional
+	Schedule []ScheduleWindow `json:"schedule,omitempty"`
+
+	// MetadataTarget is where to store the query metadata: lastQueryTime,
+	// rowCount, totalRecords, truncated, queryHash, the fingerprint of the
+	// query, scope and output options, the clientId of the service principal
+	// used, the lastError of a failed query kept by OnError, whether an empty
+	// result kept by OnEmpty left the data stale and the content hashes of
+	// the targets for Notify. Defaults to the first status target, or the
+	// first target, suffixed with Metadata, e.g. status.vnetsMetadata.
+	// Written when set, when a query interval, schedule,
+	// SkipQueryWhenTargetHasData or Notify is set, when a refresh was
+	// requested, when the result was truncated or when metadata was written
+	// before
+	// +optional
+	MetadataTarget string `json:"metadataTarget,omitempty"`
+
+	// ConditionName replaces Function in the FunctionSuccess and FunctionSkip
+	// condition types, so the conditions of several steps do not overwrite
+	// each other. Set it to the name of the pipeline step, e.g. query-vnets
+	// sets QueryVnetsSuccess and QueryVnetsSkip. It does not default to the
+	// step name, as Crossplane does not pass the step name to functions
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_.-]*$`
+	// +kubebuilder:validation:MaxLength=48
+	// +optional
+	ConditionName string `json:"conditionName,omitempty"`
+
+	// Diff writes which rows were added, removed or changed since the last
+	// query to a target
+	// +optional
+	Diff *Diff `json:"diff,omitempty"`
+
+	// Notify publishes a CloudEvent to an HTTP endpoint whenever the content
+	// of a target changes
+	// +optional
+	Notify *Notify `json:"notify,omitempty"`
+
+	// Overrides let single XRs override settings of this Input with the value
+	// of one of their fields. Settings that are not listed cannot be
+	// overridden
+	// +optional
+	Overrides []Override `json:"overrides,omitempty"`
+
 	// Identity defines the type of identity used for authentication to the Microsoft Graph API.
 	// +optional
 	Identity *Identity `json:"identity,omitempty"`
+
+	// ProviderConfigRef references an Azure ProviderConfig whose credentials Secret
+	// is used for authentication instead of the azure-creds function credentials.
+	// The ProviderConfig and its Secret are requested as required resources.
+	// +optional
+	ProviderConfigRef *ProviderConfigReference `json:"providerConfigRef,omitempty"`
+}
+
+// Diff compares the rows written to a status target with its previous value
+// by a key column. Only the keys are written, not the rows.
+type Diff struct {
+	// KeyColumn identifies a row, e.g. id
+	KeyColumn string `json:"keyColumn"`
+
+	// Of is the status target whose rows are compared. It must hold an array
+	// of rows. Defaults to the first status target
+	// +optional
+	Of string `json:"of,omitempty"`
+
+	// Target is the status or context field the summary is written to, e.g.
+	// status.vnetChanges. It is written only when rows changed
+	Target string `json:"target"`
+}
+
+// Notify configures the endpoint change events are published to.
+type Notify struct {
+	// URL of the HTTP endpoint the events are POSTed to, e.g. a webhook or a
+	// CloudEvents sink
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// SigningSecretRef selects the function credentials holding the secret
+	// the events are signed with. Events are not signed without it
+	// +optional
+	SigningSecretRef *SigningSecretRef `json:"signingSecretRef,omitempty"`
+
+	// TimeoutSeconds bounds each delivery attempt. Default is 5
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=30
+	// +optional
+	TimeoutSeconds *int `json:"timeoutSeconds,omitempty"`
+
+	// MaxRetries is the number of times a failed delivery is retried. Default
+	// is 2
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=5
+	// +optional
+	MaxRetries *int `json:"maxRetries,omitempty"`
+}
+
+// SigningSecretRef selects a key of the credentials passed to the function.
+type SigningSecretRef struct {
+	// Name of the credentials in the pipeline step
+	Name string `json:"name"`
+
+	// Key of the secret. Default is secret
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// Override sets an Input setting from a field of the XR, if the XR has it.
+type Override struct {
+	// Setting is the Input setting to override
+	// +kubebuilder:validation:Enum=queryInterval;queryIntervalMinutes;jitter;skipQueryWhenTargetHasData;subscriptions;managementGroups;maxRows;maxBytes;onLimitExceeded
+	Setting OverrideSetting `json:"setting"`
+
+	// FromFieldPath is the XR field holding the value, e.g.
+	// spec.parameters.queryInterval or
+	// metadata.annotations[example.org/max-rows]. Numbers and booleans may be
+	// given as strings, so annotations can hold them
+	FromFieldPath string `json:"fromFieldPath"`
+}
+
+// OverrideSetting is an Input setting that may be overridden per XR.
+type OverrideSetting string
+
+const (
+	// OverrideQueryInterval overrides QueryInterval
+	OverrideQueryInterval OverrideSetting = "queryInterval"
+	// OverrideQueryIntervalMinutes overrides QueryIntervalMinutes
+	OverrideQueryIntervalMinutes OverrideSetting = "queryIntervalMinutes"
+	// OverrideJitter overrides Jitter
+	OverrideJitter OverrideSetting = "jitter"
+	// OverrideSkipQueryWhenTargetHasData overrides SkipQueryWhenTargetHasData
+	OverrideSkipQueryWhenTargetHasData OverrideSetting = "skipQueryWhenTargetHasData"
+	// OverrideSubscriptions overrides Subscriptions
+	OverrideSubscriptions OverrideSetting = "subscriptions"
+	// OverrideManagementGroups overrides ManagementGroups
+	OverrideManagementGroups OverrideSetting = "managementGroups"
+	// OverrideMaxRows overrides MaxRows
+	OverrideMaxRows OverrideSetting = "maxRows"
+	// OverrideMaxBytes overrides MaxBytes
+	OverrideMaxBytes OverrideSetting = "maxBytes"
+	// OverrideOnLimitExceeded overrides OnLimitExceeded
+	OverrideOnLimitExceeded OverrideSetting = "onLimitExceeded"
+)
+
+// ScheduleWindow is a time window in which queries are allowed. Exactly one of
+// Cron and Hours must be set.
+type ScheduleWindow struct {
+	// Cron is a five-field cron expression matching the minutes in which
+	// queries are allowed, e.g. "* 1-5 * * 1-5" for 01:00 to 05:59 on weekdays
+	// +optional
+	Cron string `json:"cron,omitempty"`
+
+	// Hours is the range of hours in which queries are allowed, from the start
+	// hour up to but excluding the end hour, e.g. "22-6" for 22:00 to 05:59
+	// +optional
+	Hours string `json:"hours,omitempty"`
+
+	// TimeZone is the IANA time zone of the window. Default is UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ProviderConfigReference references a provider-family-azure ProviderConfig.
+type ProviderConfigReference struct {
+	// Name of the ProviderConfig.
+	Name string `json:"name"`
+
+	// Namespace of the ProviderConfig. Only set for namespaced ProviderConfigs.
+	// +optional
+	Namespace *string `json:"namespace,omitempty"`
+
+	// APIVersion of the ProviderConfig. Defaults to azure.upbound.io/v1beta1
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the ProviderConfig. Defaults to ProviderConfig
+	// +optional
+	Kind string `json:"kind,omitempty"`
+}
+
+// Target is a location where to store the Query Result.
+type Target struct {
+	// Path where to store the Query Result. Example: status.vnetIds or context.vnets
+	Path string `json:"path"`
+
+	// Select projects the rows to these columns before Transform and OutputFormat
+	// +optional
+	Select []string `json:"select,omitempty"`
+
+	// Transform reshapes the query result before it is written to the Path
+	// +optional
+	Transform *Transform `json:"transform,omitempty"`
+
+	// OutputFormat controls the shape of the value written to the Path. Default is list
+	// +kubebuilder:validation:Enum=list;first;scalar;map;table
+	// +optional
+	OutputFormat OutputFormat `json:"outputFormat,omitempty"`
+
+	// Column whose value is written by the scalar OutputFormat
+	// +optional
+	Column string `json:"column,omitempty"`
+
+	// KeyBy is the column whose values key the map OutputFormat
+	// +optional
+	KeyBy string `json:"keyBy,omitempty"`
+
+	// MergeStrategy controls how the result is combined with the value already
+	// at the Path. Default is replace
+	// +kubebuilder:validation:Enum=replace;deepMerge;append;upsertByKey
+	// +optional
+	MergeStrategy MergeStrategy `json:"mergeStrategy,omitempty"`
+
+	// MergeKey is the column identifying rows for the upsertByKey MergeStrategy
+	// +optional
+	MergeKey string `json:"mergeKey,omitempty"`
+}
+
+// Transform is an expression evaluated against the query result rows, which
+// are passed in as an array of objects.
+type Transform struct {
+	// Language of the expression. Default is jq
+	// +kubebuilder:validation:Enum=jq;jmespath
+	// +optional
+	Language TransformLanguage `json:"language,omitempty"`
+
+	// Expression producing the value written to the Target. Example: 'map({(.name): .location}) | add'
+	Expression string `json:"expression"`
 }
 
 // Identity defines the type of identity used for authentication to the Microsoft Graph API.
@@ -79,3 +428,139 @@ const (
 // IdentityType controls type of credentials to use for authentication to the Microsoft Graph API.
 // Supported values: AzureServicePrincipalCredentials;AzureWorkloadIdentityCredentials
 type IdentityType string
+
+// ScopePolicy controls how Input and credential subscriptions are merged.
+type ScopePolicy string
+
+const (
+	// ScopePolicyOverride uses the Input subscriptions if set, otherwise the credential subscriptions
+	ScopePolicyOverride ScopePolicy = "override"
+	// ScopePolicyUnion uses both the Input and the credential subscriptions
+	ScopePolicyUnion ScopePolicy = "union"
+	// ScopePolicyIntersect restricts the Input subscriptions to the credential subscriptions
+	ScopePolicyIntersect ScopePolicy = "intersect"
+)
+
+// TransformLanguage is the expression language of a Transform.
+type TransformLanguage string
+
+const (
+	// TransformLanguageJQ evaluates the expression with jq
+	TransformLanguageJQ TransformLanguage = "jq"
+	// TransformLanguageJMESPath evaluates the expression with JMESPath
+	TransformLanguageJMESPath TransformLanguage = "jmespath"
+)
+
+// OutputFormat is the shape of the value written to a target.
+type OutputFormat string
+
+const (
+	// OutputFormatList writes the rows as an array of objects
+	OutputFormatList OutputFormat = "list"
+	// OutputFormatFirst writes the first row as an object
+	OutputFormatFirst OutputFormat = "first"
+	// OutputFormatScalar writes the value of one column in the first row
+	OutputFormatScalar OutputFormat = "scalar"
+	// OutputFormatMap writes the rows as an object keyed by a column
+	OutputFormatMap OutputFormat = "map"
+	// OutputFormatTable writes the column names and the row values
+	OutputFormatTable OutputFormat = "table"
+)
+
+// MergeStrategy controls how a result is combined with the existing value of a target.
+type MergeStrategy string
+
+const (
+	// MergeStrategyReplace replaces the existing value
+	MergeStrategyReplace MergeStrategy = "replace"
+	// MergeStrategyDeepMerge recursively merges objects, the result wins on conflicts
+	MergeStrategyDeepMerge MergeStrategy = "deepMerge"
+	// MergeStrategyAppend appends the result to an existing array
+	MergeStrategyAppend MergeStrategy = "append"
+	// MergeStrategyUpsertByKey replaces rows with the same MergeKey and appends new ones
+	MergeStrategyUpsertByKey MergeStrategy = "upsertByKey"
+)
+
+// LimitAction controls what happens when a result exceeds MaxRows or MaxBytes.
+type LimitAction string
+
+const (
+	// LimitActionTruncate drops the trailing rows until the result fits
+	LimitActionTruncate LimitAction = "truncate"
+	// LimitActionFail reports a fatal result and writes nothing
+	LimitActionFail LimitAction = "fail"
+	// LimitActionKeepPrevious leaves the targets untouched
+	LimitActionKeepPrevious LimitAction = "keepPrevious"
+)
+
+// Expectation is a check of the query result rows. Every check that is set
+// must hold.
+type Expectation struct {
+	// MinRows is the fewest rows the query must return
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinRows *int `json:"minRows,omitempty"`
+
+	// MaxRows is the most rows the query may return
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRows *int `json:"maxRows,omitempty"`
+
+	// ExactlyOne requires the query to return exactly one row
+	// +optional
+	ExactlyOne bool `json:"exactlyOne,omitempty"`
+
+	// RequiredColumns must have a value other than null in every row
+	// +optional
+	RequiredColumns []string `json:"requiredColumns,omitempty"`
+
+	// Message replaces the description of the failed checks
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Outcome controls what happens when the expectation is not met. fatal
+	// reports a fatal result and writes nothing, warn emits a Warning and
+	// notReady marks the XR as not ready. warn and notReady still write the
+	// rows. Default is fatal
+	// +kubebuilder:validation:Enum=fatal;warn;notReady
+	// +optional
+	Outcome ExpectationOutcome `json:"outcome,omitempty"`
+}
+
+// ExpectationOutcome controls what happens when an expectation is not met.
+type ExpectationOutcome string
+
+const (
+	// ExpectationOutcomeFatal reports a fatal result and writes nothing
+	ExpectationOutcomeFatal ExpectationOutcome = "fatal"
+	// ExpectationOutcomeWarn emits a Warning
+	ExpectationOutcomeWarn ExpectationOutcome = "warn"
+	// ExpectationOutcomeNotReady marks the XR as not ready
+	ExpectationOutcomeNotReady ExpectationOutcome = "notReady"
+)
+
+// EmptyAction controls what happens when a query returns no rows.
+type EmptyAction string
+
+const (
+	// EmptyActionWrite writes the empty result
+	EmptyActionWrite EmptyAction = "write"
+	// EmptyActionKeepPrevious keeps the data of the targets
+	EmptyActionKeepPrevious EmptyAction = "keepPrevious"
+	// EmptyActionWarn keeps the data of the targets and emits a Warning
+	EmptyActionWarn EmptyAction = "warn"
+	// EmptyActionFatal reports a fatal result and writes nothing
+	EmptyActionFatal EmptyAction = "fatal"
+)
+
+// ErrorAction controls what happens when a query fails.
+type ErrorAction string
+
+const (
+	// ErrorActionFatal reports a fatal result and stops the pipeline
+	ErrorActionFatal ErrorAction = "fatal"
+	// ErrorActionWarn emits a Warning and keeps the data of the targets
+	ErrorActionWarn ErrorAction = "warn"
+	// ErrorActionIgnore keeps the data of the targets without a result
+	ErrorActionIgnore ErrorAction = "ignore"
+)
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// errKeepPrevious is returned when a limit is exceeded and the targets should
+// keep their previous value.
+var errKeepPrevious = errors.New("keeping the previous value")
+
+// resultLimits guard against results too large to be written to the XR.
+type resultLimits struct {
+	maxRows    int
+	maxBytes   int
+	onExceeded v1beta1.LimitAction
+}
+
+// resultLimits returns the limits of the Input, falling back to the
+// function-level defaults.
+func (f *Function) resultLimits(in *v1beta1.Input) resultLimits {
+	l := resultLimits{maxRows: f.maxRows, maxBytes: f.maxBytes, onExceeded: in.OnLimitExceeded}
+	if in.MaxRows != nil {
+		l.maxRows = *in.MaxRows
+	}
+	if in.MaxBytes != nil {
+		l.maxBytes = *in.MaxBytes
+	}
+	if l.onExceeded == "" {
+		l.onExceeded = v1beta1.LimitActionTruncate
+	}
+	return l
+}
+
+// exceeded returns the error for a limit that was hit, nil if the result may
+// be truncated.
+func (l resultLimits) exceeded(format string, args ...interface{}) error {
+	switch l.onExceeded {
+	case v1beta1.LimitActionFail:
+		return errors.Errorf(format, args...)
+	case v1beta1.LimitActionKeepPrevious:
+		return errors.Wrapf(errKeepPrevious, format, args...)
+	default:
+		return nil
+	}
+}
+
+// limitRows applies maxRows to the query rows. It returns a warning if the
+// rows were truncated.
+func (l resultLimits) limitRows(data interface{}) (interface{}, string, error) {
+	rows, ok := data.([]interface{})
+	if !ok || l.maxRows <= 0 || len(rows) <= l.maxRows {
+		return data, "", nil
+	}
+	if err := l.exceeded("query returned %d rows, exceeding maxRows %d", len(rows), l.maxRows); err != nil {
+		return nil, "", err
+	}
+	return rows[:l.maxRows], fmt.Sprintf("query returned %d rows, truncated to maxRows %d", len(rows), l.maxRows), nil
+}
+
+// limitBytes applies maxBytes to the value written to a target by dropping
+// trailing elements of an array. Other values cannot be truncated. It returns
+// a warning if the value was truncated.
+func (l resultLimits) limitBytes(data interface{}) (interface{}, string, error) {
+	if l.maxBytes <= 0 {
+		return data, "", nil
+	}
+	size, err := jsonSize(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if size <= l.maxBytes {
+		return data, "", nil
+	}
+	if err := l.exceeded("result of %d bytes exceeds maxBytes %d", size, l.maxBytes); err != nil {
+		return nil, "", err
+	}
+
+	rows, ok := data.([]interface{})
+	if !ok {
+		return nil, "", errors.Errorf("result of %d bytes exceeds maxBytes %d and is %s, only arrays can be truncated", size, l.maxBytes, jsonType(data))
+	}
+	// An array is the brackets plus its elements separated by commas
+	kept, keptSize := 0, len("[]")
+	for i, row := range rows {
+		rowSize, err := jsonSize(row)
+		if err != nil {
+			return nil, "", err
+		}
+		if i > 0 {
+			rowSize++
+		}
+		if keptSize+rowSize > l.maxBytes {
+			break
+		}
+		kept, keptSize = i+1, keptSize+rowSize
+	}
+	return rows[:kept], fmt.Sprintf("result of %d bytes truncated to %d of %d rows to fit maxBytes %d", size, kept, len(rows), l.maxBytes), nil
+}
+
+func jsonSize(v interface{}) (int, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot measure result size")
+	}
+	return len(b), nil
+}
+
+// keepContextTargets restores the context targets when a limit keeps the
+// previous value of every target. Status targets keep their value in the
+// desired XR, the context does not survive between reconciles. A requested
+// refresh was handled all the same.
+func (f *Function) keepContextTargets(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) {
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets that keep their previous value", "error", err)
+	}
+	if observedAnnotation(req, RefreshAnnotation) == "" {
+		return
+	}
+	if err := f.updateQueryMetadata(req, in, withRefresh(req, map[string]interface{}{}), rsp); err != nil {
+		f.log.Debug("Cannot record the handled refresh in the query metadata", "error", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestResultLimits(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	// metadata is the query metadata of the case, whose Input sets the
+	// fingerprint in place of $fingerprint
+	metadata := func(rowCount int, truncated bool) string {
+		return fmt.Sprintf(`{"lastQueryTime":%q,"queryHash":%q,"fingerprint":"$fingerprint","rowCount":%d,"truncated":%t}`,
+			now.Format(time.RFC3339), queryHash("Resources| count"), rowCount, truncated)
+	}
+	warning := func(msg string) *fnv1.Result {
+		return &fnv1.Result{Severity: fnv1.Severity_SEVERITY_WARNING, Message: msg, Target: fnv1.Target_TARGET_COMPOSITE.Enum()}
+	}
+	fatal := func(msg string) *fnv1.Result {
+		return &fnv1.Result{Severity: fnv1.Severity_SEVERITY_FATAL, Message: msg, Target: fnv1.Target_TARGET_COMPOSITE.Enum()}
+	}
+
+	type args struct {
+		maxRows int
+		input   string
+		xr      string
+	}
+	type want struct {
+		status  string
+		context string
+		results []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"TruncateRows": {
+			reason: "Rows beyond maxRows should be dropped with a warning and the truncated flag in the query metadata",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 2
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"},{"id":"/b"}],"vnetsMetadata":` + metadata(2, true) + `}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried, warning("query returned 3 rows, truncated to maxRows 2")},
+			},
+		},
+		"FunctionDefault": {
+			reason: "The function-level maxRows should apply when the Input does not set one",
+			args: args{
+				maxRows: 1,
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.[inventory.vnets]"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{"inventory.vnets":[{"id":"/a"}],"inventory.vnetsMetadata":` + metadata(1, true) + `}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried, warning("query returned 3 rows, truncated to maxRows 1")},
+			},
+		},
+		"InputDisablesDefault": {
+			reason: "maxRows 0 in the Input should disable the function-level limit and clear an earlier truncated flag",
+			args: args{
+				maxRows: 1,
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 0
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/a"}],"vnetsMetadata":{"truncated":true}}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"},{"id":"/b"},{"id":"/c"}],"vnetsMetadata":` + metadata(3, false) + `}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"FailRows": {
+			reason: "onLimitExceeded fail should report a fatal result and write nothing",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 2,
+					"onLimitExceeded": "fail"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried, fatal("query returned 3 rows, exceeding maxRows 2")},
+			},
+		},
+		"KeepPreviousRows": {
+			reason: "onLimitExceeded keepPrevious should leave the targets untouched with a warning",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 2,
+					"onLimitExceeded": "keepPrevious"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{queried, warning("query returned 3 rows, exceeding maxRows 2: keeping the previous value")},
+			},
+		},
+		"KeepPreviousRowsRestoresContext": {
+			reason: "onLimitExceeded keepPrevious should restore the cached context targets",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.vnets",
+					"maxRows": 2,
+					"onLimitExceeded": "keepPrevious"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetsMetadata":{"cache":{"context.vnets":[{"id":"/old"}]}}}}`,
+			},
+			want: want{
+				status:  `{"vnetsMetadata":{"cache":{"context.vnets":[{"id":"/old"}]}}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]},"vnets":[{"id":"/old"}]}`,
+				results: []*fnv1.Result{queried, warning("query returned 3 rows, exceeding maxRows 2: keeping the previous value")},
+			},
+		},
+		"KeepPreviousBytesRestoresContext": {
+			reason: "A context target keeping its previous value because of maxBytes should be restored from the cache",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"targets": [{"path": "context.vnets"}, {"path": "status.vnetCount", "outputFormat": "scalar", "transform": {"expression": "[{count: length}]"}}],
+					"queryIntervalMinutes": 10,
+					"maxBytes": 25,
+					"onLimitExceeded": "keepPrevious"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetCountMetadata":{
+					"lastQueryTime":"` + now.Add(-time.Hour).Format(time.RFC3339) + `","cache":{"context.vnets":[{"id":"/old"}]}}}}`,
+			},
+			want: want{
+				status: `{"vnetCount":3,"vnetCountMetadata":{"lastQueryTime":"` + now.Format(time.RFC3339) + `","queryHash":"` + queryHash("Resources| count") + `",
+					"fingerprint":"$fingerprint","rowCount":3,"truncated":false,"cache":{"context.vnets":[{"id":"/old"}]}}}`,
+				context: `{"vnets":[{"id":"/old"}]}`,
+				results: []*fnv1.Result{queried, warning("target context.vnets: result of 37 bytes exceeds maxBytes 25: keeping the previous value")},
+			},
+		},
+		"CacheExceedsBytes": {
+			reason: "Context targets that together exceed maxBytes should not be cached in the query metadata",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"targets": [{"path": "context.vnets"}, {"path": "context.vnetIds", "transform": {"expression": "map(.id)"}}],
+					"metadataTarget": "status.vnetsMetadata",
+					"queryIntervalMinutes": 10,
+					"maxBytes": 40
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{"vnetsMetadata":` + metadata(3, false) + `}`,
+				context: `{"vnets":[{"id":"/a"},{"id":"/b"},{"id":"/c"}],"vnetIds":["/a","/b","/c"]}`,
+				results: []*fnv1.Result{queried, warning("context targets of 53 bytes exceed maxBytes 40, not caching them in the query metadata; skipped queries run again instead")},
+			},
+		},
+		"TruncateBytes": {
+			reason: "Trailing rows should be dropped until the value written to each target fits maxBytes",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"targets": [{"path": "context.vnets"}, {"path": "status.vnetCount", "outputFormat": "scalar", "transform": {"expression": "[{count: length}]"}}],
+					"maxBytes": 25
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{"vnetCount":3,"vnetCountMetadata":` + metadata(3, true) + `}`,
+				context: `{"vnets":[{"id":"/a"},{"id":"/b"}]}`,
+				results: []*fnv1.Result{queried, warning("target context.vnets: result of 37 bytes truncated to 2 of 3 rows to fit maxBytes 25")},
+			},
+		},
+		"BytesNotAnArray": {
+			reason: "A value other than an array exceeding maxBytes cannot be truncated",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"outputFormat": "map",
+					"keyBy": "id",
+					"maxBytes": 25
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried, fatal("target status.vnets: result of 52 bytes exceeds maxBytes 25 and is an object, only arrays can be truncated")},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(map[string]interface{}{"id": "/a"},
+					map[string]interface{}{"id": "/b"},
+					map[string]interface{}{"id": "/c"}),
+				log:     logging.NewNopLogger(),
+				maxRows: tc.args.maxRows,
+				now:     func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), reconcile(tc.args.input, tc.args.xr))
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			want := strings.ReplaceAll(tc.want.status, "$fingerprint", inputFingerprint(t, tc.args.input))
+			if diff := cmp.Diff(resource.MustStructJSON(want), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
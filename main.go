@@ -2,6 +2,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/alecthomas/kong"
 
 	"github.com/crossplane/function-sdk-go"
@@ -16,6 +18,22 @@ type CLI struct {
 	TLSCertsDir        string `help:"Directory containing server certs (tls.key, tls.crt) and the CA used to verify client certificates (ca.crt)" env:"TLS_SERVER_CERTS_DIR"`
 	Insecure           bool   `help:"Run without mTLS credentials. If you supply this flag --tls-server-certs-dir will be ignored."`
 	MaxRecvMessageSize int    `help:"Maximum size of received messages in MB." default:"4"`
+
+	RequireExplicitScope bool `help:"Reject queries without subscriptions or management groups unless the Input sets allowTenantScope."`
+
+	PolicyFile              string   `help:"YAML or JSON file with allowedSubscriptions, allowedManagementGroups, allowedTables and allowedNotifyURLs lists." env:"POLICY_FILE"`
+	AllowedSubscriptions    []string `help:"Subscriptions queries may run against. Empty allows all."`
+	AllowedManagementGroups []string `help:"Management groups queries may run against. Empty allows all."`
+	AllowedTables           []string `help:"Azure Resource Graph tables queries may read, e.g. Resources. Empty allows all."`
+	AllowedNotifyURLs       []string `help:"Hosts or URL prefixes events may be delivered to, e.g. https://hooks.example.com/crossplane. Empty allows all."`
+
+	MaxRows  int `help:"Default maximum number of query result rows written to the targets. 0 disables the limit." env:"MAX_ROWS"`
+	MaxBytes int `help:"Default maximum JSON size in bytes of the value written to each target. 0 disables the limit." env:"MAX_BYTES"`
+
+	MinTTL time.Duration `help:"Minimum time Crossplane caches a response before calling the function again." default:"10s" env:"MIN_TTL"`
+	MaxTTL time.Duration `help:"Maximum time Crossplane caches a response before calling the function again. 0 disables the limit." default:"1h" env:"MAX_TTL"`
+
+	NotifyBudget time.Duration `help:"Maximum time the change event deliveries of a single call take together, retries included." default:"10s" env:"NOTIFY_BUDGET"`
 }
 
 // Run this Function.
@@ -25,7 +43,26 @@ func (c *CLI) Run() error {
 		return err
 	}
 
-	return function.Serve(&Function{log: log},
+	policy, err := LoadPolicy(c.PolicyFile, Policy{
+		AllowedSubscriptions:    c.AllowedSubscriptions,
+		AllowedManagementGroups: c.AllowedManagementGroups,
+		AllowedTables:           c.AllowedTables,
+		AllowedNotifyURLs:       c.AllowedNotifyURLs,
+	})
+	if err != nil {
+		return err
+	}
+
+	return function.Serve(&Function{
+		log:                  log,
+		requireExplicitScope: c.RequireExplicitScope,
+		policy:               policy,
+		maxRows:              c.MaxRows,
+		maxBytes:             c.MaxBytes,
+		minTTL:               c.MinTTL,
+		maxTTL:               c.MaxTTL,
+		notifyBudget:         c.NotifyBudget,
+	},
 		function.Listen(c.Network, c.Address),
 		function.MTLSCertificates(c.TLSCertsDir),
 		function.Insecure(c.Insecure),
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+)
+
+// pipelineStateKey is the pipeline context key this function keeps the state
+// of the current run under, so later steps of the function can tell the
+// observed status it carried forward into the desired XR from results. The
+// value is an object with:
+//
+//   - carriedStatus: the top level status fields copied from the observed XR
+//     because the desired XR had no status yet.
+//   - writtenStatus: the status targets within those fields written since.
+//
+// The key only exists when the observed status was carried forward.
+const pipelineStateKey = "azresourcegraph.fn.crossplane.io/pipeline-state"
+
+// pipelineState is the value of the pipelineStateKey context key.
+type pipelineState struct {
+	CarriedStatus []string `json:"carriedStatus,omitempty"`
+	WrittenStatus []string `json:"writtenStatus,omitempty"`
+}
+
+// mergeIntoTarget combines the value for a target with the value earlier
+// pipeline steps wrote to it. The observed XR status is deliberately not used
+// as the base, otherwise append would grow the target on every reconcile.
+// That includes the observed status earlier steps of this function carried
+// forward into the desired XR.
+func mergeIntoTarget(req *fnv1.RunFunctionRequest, t v1beta1.Target, value interface{}) (interface{}, error) {
+	if t.MergeStrategy == "" || t.MergeStrategy == v1beta1.MergeStrategyReplace {
+		return value, nil
+	}
+
+	existing, err := existingTargetValue(req, t.Path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(t.Path, "status.") {
+		existing = withoutLastQueryTime(existing)
+	}
+	return mergeValue(t.MergeStrategy, t.MergeKey, existing, value)
+}
+
+// existingTargetValue returns the value at the target path in the desired XR
+// or context of the request, nil if there is none.
+func existingTargetValue(req *fnv1.RunFunctionRequest, path string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(path, "status."):
+		if readPipelineState(req.GetContext()).carried(path) {
+			return nil, nil
+		}
+		dxr, err := request.GetDesiredCompositeResource(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get desired composite resource")
+		}
+		status, _ := dxr.Resource.Object["status"].(map[string]interface{})
+		v, _ := getNestedValue(status, strings.TrimPrefix(path, "status."))
+		return v, nil
+	case strings.HasPrefix(path, "context."):
+		v, _ := getNestedValue(req.GetContext().AsMap(), strings.TrimPrefix(path, "context."))
+		return v, nil
+	default:
+		return nil, errors.Errorf("Unrecognized target field: %s", path)
+	}
+}
+
+// carried reports whether the value at the status path is still the observed
+// value carried forward, i.e. it is in a carried field and no step of this
+// function wrote it, or a path within or around it, since.
+func (s pipelineState) carried(path string) bool {
+	for _, w := range s.WrittenStatus {
+		if w == path || isSubPath(w, path) || isSubPath(path, w) {
+			return false
+		}
+	}
+	return s.inCarriedField(path)
+}
+
+// inCarriedField reports whether the status path is in a carried field.
+func (s pipelineState) inCarriedField(path string) bool {
+	field, _ := statusField(path)
+	for _, c := range s.CarriedStatus {
+		if c == field {
+			return true
+		}
+	}
+	return false
+}
+
+// readPipelineState returns the state of the current run in the context,
+// empty if there is none.
+func readPipelineState(c *structpb.Struct) pipelineState {
+	s := pipelineState{}
+	v, ok := c.AsMap()[pipelineStateKey]
+	if !ok {
+		return s
+	}
+	if b, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(b, &s)
+	}
+	return s
+}
+
+// writePipelineState sets the state of the current run in the response
+// context, or removes it once nothing is carried.
+func writePipelineState(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, s pipelineState) error {
+	c := rsp.GetContext().AsMap()
+	if _, ok := c[pipelineStateKey]; !ok && len(s.CarriedStatus) == 0 {
+		return nil
+	}
+	delete(c, pipelineStateKey)
+	if len(s.CarriedStatus) > 0 {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return errors.Wrap(err, "cannot marshal pipeline state")
+		}
+		v := map[string]interface{}{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return errors.Wrap(err, "cannot unmarshal pipeline state")
+		}
+		c[pipelineStateKey] = v
+	}
+	if len(c) == 0 {
+		// Hand back the context as it came when nothing else is in it
+		rsp.Context = req.GetContext()
+		return nil
+	}
+
+	ctx, err := structpb.NewStruct(c)
+	if err != nil {
+		return errors.Wrap(err, "cannot serialize context")
+	}
+	rsp.Context = ctx
+	return nil
+}
+
+// recordCarriedStatus notes the status fields copied from the observed XR into
+// the desired XR of the response.
+func recordCarriedStatus(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, status map[string]interface{}) error {
+	if len(status) == 0 {
+		return nil
+	}
+	s := pipelineState{}
+	for k := range status {
+		s.CarriedStatus = append(s.CarriedStatus, k)
+	}
+	sort.Strings(s.CarriedStatus)
+	return writePipelineState(req, rsp, s)
+}
+
+// recordWrittenStatus notes a status target written in a carried field, so a
+// later merge step takes it as written during this run even when it equals the
+// observed value. A field written as a whole is no longer carried.
+func recordWrittenStatus(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, path string) error {
+	s := readPipelineState(rsp.GetContext())
+	if !strings.HasPrefix(path, "status.") || !s.inCarriedField(path) {
+		return nil
+	}
+	if field, whole := statusField(path); whole {
+		s.CarriedStatus = without(s.CarriedStatus, field)
+	} else if !contains(s.WrittenStatus, path) {
+		s.WrittenStatus = append(s.WrittenStatus, path)
+	}
+	return writePipelineState(req, rsp, s)
+}
+
+// hasDesiredStatus reports whether earlier pipeline steps handed this function
+// a desired XR status.
+func hasDesiredStatus(req *fnv1.RunFunctionRequest) bool {
+	return len(req.GetDesired().GetComposite().GetResource().GetFields()["status"].GetStructValue().GetFields()) > 0
+}
+
+// statusField returns the top level status field of a status path and
+// whether the path is that field itself.
+func statusField(path string) (string, bool) {
+	parts, err := ParseNestedKey(strings.TrimPrefix(path, "status."))
+	if err != nil || len(parts) == 0 {
+		return "", false
+	}
+	return parts[0], len(parts) == 1
+}
+
+// isSubPath reports whether path is within parent.
+func isSubPath(path, parent string) bool {
+	return strings.HasPrefix(path, parent+".") || strings.HasPrefix(path, parent+"[")
+}
+
+// getNestedValue retrieves a nested value of any type from a map using dot
+// and bracket notation keys.
+func getNestedValue(root map[string]interface{}, key string) (interface{}, bool) {
+	parts, err := ParseNestedKey(key)
+	if err != nil {
+		return nil, false
+	}
+
+	current := interface{}(root)
+	for _, k := range parts {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// mergeValue combines an incoming value with an existing one according to the
+// merge strategy. A nil existing value always results in the incoming value.
+func mergeValue(strategy v1beta1.MergeStrategy, mergeKey string, existing, incoming interface{}) (interface{}, error) {
+	switch strategy {
+	case "", v1beta1.MergeStrategyReplace:
+		return incoming, nil
+	case v1beta1.MergeStrategyDeepMerge:
+		if existing == nil {
+			return incoming, nil
+		}
+		return deepMerge("", existing, incoming)
+	case v1beta1.MergeStrategyAppend:
+		existingRows, err := mergeRows(strategy, existing)
+		if err != nil {
+			return nil, err
+		}
+		return append(existingRows, asRows(incoming)...), nil
+	case v1beta1.MergeStrategyUpsertByKey:
+		return upsertByKey(mergeKey, existing, incoming)
+	default:
+		return nil, errors.Errorf("unsupported mergeStrategy: %s", string(strategy))
+	}
+}
+
+// deepMerge recursively merges incoming objects into existing ones. Values
+// other than objects, including arrays, are replaced by the incoming value.
+// Merging an object with anything other than an object is an error.
+func deepMerge(path string, existing, incoming interface{}) (interface{}, error) {
+	existingMap, existingIsMap := existing.(map[string]interface{})
+	incomingMap, incomingIsMap := incoming.(map[string]interface{})
+
+	switch {
+	case existing == nil || incoming == nil:
+		return incoming, nil
+	case existingIsMap && incomingIsMap:
+		merged := make(map[string]interface{}, len(existingMap)+len(incomingMap))
+		for k, v := range existingMap {
+			merged[k] = v
+		}
+		for k, v := range incomingMap {
+			m, err := deepMerge(joinPath(path, k), merged[k], v)
+			if err != nil {
+				return nil, err
+			}
+			merged[k] = m
+		}
+		return merged, nil
+	case existingIsMap || incomingIsMap:
+		return nil, errors.Errorf("mergeStrategy deepMerge: cannot merge %s into %s at %s", jsonType(incoming), jsonType(existing), displayPath(path))
+	default:
+		return incoming, nil
+	}
+}
+
+// upsertByKey replaces existing rows that have the same value in the merge key
+// column as an incoming row, in place, and appends the other incoming rows.
+// Existing rows without the key are kept as they are.
+func upsertByKey(mergeKey string, existing, incoming interface{}) (interface{}, error) {
+	if mergeKey == "" {
+		return nil, errors.New("mergeStrategy upsertByKey requires mergeKey")
+	}
+	rows, err := mergeRows(v1beta1.MergeStrategyUpsertByKey, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(rows))
+	for i, row := range rows {
+		if key, ok := rowKey(row, mergeKey); ok {
+			index[key] = i
+		}
+	}
+
+	for i, row := range asRows(incoming) {
+		key, ok := rowKey(row, mergeKey)
+		if !ok {
+			return nil, errors.Errorf("mergeStrategy upsertByKey: incoming row %d has no scalar value for mergeKey %q", i, mergeKey)
+		}
+		if at, exists := index[key]; exists {
+			rows[at] = row
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// mergeRows returns a copy of the existing array, which must be an array or nil.
+func mergeRows(strategy v1beta1.MergeStrategy, existing interface{}) ([]interface{}, error) {
+	switch v := existing.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case []interface{}:
+		return append([]interface{}{}, v...), nil
+	default:
+		return nil, errors.Errorf("mergeStrategy %s: existing value is %s, expected an array", strategy, jsonType(existing))
+	}
+}
+
+// asRows returns the incoming value as rows. A single value, e.g. from the
+// first output format, is a single row.
+func asRows(incoming interface{}) []interface{} {
+	switch v := incoming.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// rowKey returns the merge key of a row as a string.
+func rowKey(row interface{}, mergeKey string) (string, bool) {
+	m, ok := row.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	switch k := m[mergeKey].(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(k), true
+	}
+}
+
+// jsonType names the JSON type of a value for error messages.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "the target"
+	}
+	return path
+}
+
+// contains reports whether the list has s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns the list without s.
+func without(list []string, s string) []string {
+	out := []string{}
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
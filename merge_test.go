@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestMergeValue(t *testing.T) {
+	type args struct {
+		strategy v1beta1.MergeStrategy
+		mergeKey string
+		existing interface{}
+		incoming interface{}
+	}
+	type want struct {
+		value interface{}
+		err   string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Replace": {
+			reason: "The default strategy should replace the existing value",
+			args: args{
+				existing: []interface{}{"a"},
+				incoming: map[string]interface{}{"b": "c"},
+			},
+			want: want{value: map[string]interface{}{"b": "c"}},
+		},
+		"DeepMerge": {
+			reason: "deepMerge should merge nested objects and replace other values",
+			args: args{
+				strategy: v1beta1.MergeStrategyDeepMerge,
+				existing: map[string]interface{}{
+					"keep":   "x",
+					"nested": map[string]interface{}{"a": float64(1), "list": []interface{}{"old"}},
+				},
+				incoming: map[string]interface{}{
+					"nested": map[string]interface{}{"b": float64(2), "list": []interface{}{"new"}},
+				},
+			},
+			want: want{value: map[string]interface{}{
+				"keep":   "x",
+				"nested": map[string]interface{}{"a": float64(1), "b": float64(2), "list": []interface{}{"new"}},
+			}},
+		},
+		"DeepMergeNothingExisting": {
+			reason: "deepMerge into nothing should write the incoming value",
+			args: args{
+				strategy: v1beta1.MergeStrategyDeepMerge,
+				incoming: []interface{}{"a"},
+			},
+			want: want{value: []interface{}{"a"}},
+		},
+		"DeepMergeConflict": {
+			reason: "deepMerge should report where an object meets another type",
+			args: args{
+				strategy: v1beta1.MergeStrategyDeepMerge,
+				existing: map[string]interface{}{"nested": map[string]interface{}{"a": "x"}},
+				incoming: map[string]interface{}{"nested": map[string]interface{}{"a": map[string]interface{}{"b": "y"}}},
+			},
+			want: want{err: "mergeStrategy deepMerge: cannot merge an object into a string at nested.a"},
+		},
+		"DeepMergeTopLevelConflict": {
+			reason: "deepMerge should report a conflict at the target itself",
+			args: args{
+				strategy: v1beta1.MergeStrategyDeepMerge,
+				existing: []interface{}{"a"},
+				incoming: map[string]interface{}{"b": "c"},
+			},
+			want: want{err: "mergeStrategy deepMerge: cannot merge an object into an array at the target"},
+		},
+		"Append": {
+			reason: "append should add the incoming rows to the existing array",
+			args: args{
+				strategy: v1beta1.MergeStrategyAppend,
+				existing: []interface{}{"a"},
+				incoming: []interface{}{"b", "c"},
+			},
+			want: want{value: []interface{}{"a", "b", "c"}},
+		},
+		"AppendSingleValue": {
+			reason: "append should add a single value, e.g. from outputFormat first, as one element",
+			args: args{
+				strategy: v1beta1.MergeStrategyAppend,
+				incoming: map[string]interface{}{"id": "/a"},
+			},
+			want: want{value: []interface{}{map[string]interface{}{"id": "/a"}}},
+		},
+		"AppendToObject": {
+			reason: "append should refuse to append to anything but an array",
+			args: args{
+				strategy: v1beta1.MergeStrategyAppend,
+				existing: map[string]interface{}{"a": "b"},
+				incoming: []interface{}{"c"},
+			},
+			want: want{err: "mergeStrategy append: existing value is an object, expected an array"},
+		},
+		"UpsertByKey": {
+			reason: "upsertByKey should replace rows in place and append new ones, keeping rows without a key",
+			args: args{
+				strategy: v1beta1.MergeStrategyUpsertByKey,
+				mergeKey: "id",
+				existing: []interface{}{
+					map[string]interface{}{"id": "/a", "v": float64(1)},
+					map[string]interface{}{"note": "no key"},
+					map[string]interface{}{"id": "/b", "v": float64(1)},
+				},
+				incoming: []interface{}{
+					map[string]interface{}{"id": "/b", "v": float64(2)},
+					map[string]interface{}{"id": "/c", "v": float64(2)},
+				},
+			},
+			want: want{value: []interface{}{
+				map[string]interface{}{"id": "/a", "v": float64(1)},
+				map[string]interface{}{"note": "no key"},
+				map[string]interface{}{"id": "/b", "v": float64(2)},
+				map[string]interface{}{"id": "/c", "v": float64(2)},
+			}},
+		},
+		"UpsertByKeyMissingKey": {
+			reason: "upsertByKey should reject incoming rows without the key",
+			args: args{
+				strategy: v1beta1.MergeStrategyUpsertByKey,
+				mergeKey: "id",
+				incoming: []interface{}{map[string]interface{}{"name": "a"}},
+			},
+			want: want{err: `mergeStrategy upsertByKey: incoming row 0 has no scalar value for mergeKey "id"`},
+		},
+		"UpsertByKeyRequiresMergeKey": {
+			reason: "upsertByKey should require mergeKey",
+			args: args{
+				strategy: v1beta1.MergeStrategyUpsertByKey,
+				incoming: []interface{}{},
+			},
+			want: want{err: "mergeStrategy upsertByKey requires mergeKey"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := mergeValue(tc.args.strategy, tc.args.mergeKey, tc.args.existing, tc.args.incoming)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\nmergeValue(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.value, got); diff != "" {
+				t.Errorf("%s\nmergeValue(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestMergeIntoTargets(t *testing.T) {
+	input := `{
+		"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+		"kind": "Input",
+		"query": "Resources| count",
+		"targets": [
+			{"path": "status.inventory", "mergeStrategy": "upsertByKey", "mergeKey": "id"},
+			{"path": "context.[apiextensions.crossplane.io/environment].azure", "mergeStrategy": "deepMerge", "outputFormat": "map", "keyBy": "name"}
+		]
+	}`
+
+	cases := map[string]struct {
+		reason  string
+		desired string
+		context string
+		status  string
+		want    string
+	}{
+		"MergeWithEarlierSteps": {
+			reason:  "Results should be merged with what earlier pipeline steps wrote to the desired XR and context",
+			desired: `{"apiVersion":"example.org/v1","kind":"XR","status":{"inventory":[{"id":"/x","step":"earlier"},{"id":"/a","step":"earlier"}]}}`,
+			context: `{"apiextensions.crossplane.io/environment":{"azure":{"vnet-x":{"name":"vnet-x"}},"other":"kept"}}`,
+			status:  `{"inventory":[{"id":"/x","step":"earlier"},{"id":"/a","name":"vnet-a"}]}`,
+			want:    `{"apiextensions.crossplane.io/environment":{"azure":{"vnet-x":{"name":"vnet-x"},"vnet-a":{"id":"/a","name":"vnet-a"}},"other":"kept"}}`,
+		},
+		"IgnoreObservedStatus": {
+			reason:  "The observed XR status should not be the merge base, so results do not accumulate across reconciles",
+			desired: `{}`,
+			context: `{}`,
+			status:  `{"inventory":[{"id":"/a","name":"vnet-a"}]}`,
+			want:    `{"apiextensions.crossplane.io/environment":{"azure":{"vnet-a":{"id":"/a","name":"vnet-a"}}}}`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(map[string]interface{}{"id": "/a", "name": "vnet-a"}),
+				log:        logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:  &fnv1.RequestMeta{Tag: "hello"},
+				Input: resource.MustStructJSON(input),
+				Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(
+					`{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"inventory":[{"id":"/a","name":"vnet-a"},{"id":"/old"}]}}`,
+				)}},
+				Desired:     &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.desired)}},
+				Context:     resource.MustStructJSON(tc.context),
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			for _, r := range rsp.GetResults() {
+				if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+					t.Fatalf("%s\nRunFunction(...): unexpected fatal result: %s", tc.reason, r.GetMessage())
+				}
+			}
+			status := desiredStatus(rsp)
+			if diff := cmp.Diff(resource.MustStructJSON(tc.status), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestMergeAcrossReconciles(t *testing.T) {
+	step := func(query, target string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "` + query + `",
+			"targets": [` + target + `]
+		}`
+	}
+	// rows returns the rows of a query, b returns another vnet every reconcile
+	rows := func(query string, reconcile int) []interface{} {
+		switch query {
+		case "b":
+			return []interface{}{map[string]interface{}{"id": fmt.Sprintf("/b%d", reconcile)}}
+		case "ac":
+			return []interface{}{map[string]interface{}{"id": "/a"}, map[string]interface{}{"id": "/c"}}
+		}
+		return []interface{}{map[string]interface{}{"id": "/a"}}
+	}
+
+	cases := map[string]struct {
+		reason string
+		// earlier is the status another function writes before the pipeline
+		earlier  string
+		pipeline []string
+		want     func(reconcile int) string
+	}{
+		"Append": {
+			reason:   "append after a step that carried the observed status forward should not grow the target",
+			pipeline: []string{step("a", `{"path": "status.other"}`), step("b", `{"path": "status.inventory", "mergeStrategy": "append"}`)},
+			want: func(r int) string {
+				return fmt.Sprintf(`{"other":[{"id":"/a"}],"inventory":[{"id":"/b%d"}]}`, r)
+			},
+		},
+		"AppendToEarlierStep": {
+			reason:   "append should keep what an earlier step wrote during this run, even when it equals the observed value",
+			pipeline: []string{step("a", `{"path": "status.inventory"}`), step("b", `{"path": "status.inventory", "mergeStrategy": "append"}`)},
+			want: func(r int) string {
+				return fmt.Sprintf(`{"inventory":[{"id":"/a"},{"id":"/b%d"}]}`, r)
+			},
+		},
+		"DeepMergeIntoUnchangedEarlierStep": {
+			reason: "deepMerge should keep what an earlier step wrote during this run when it equals the observed value",
+			pipeline: []string{
+				step("ac", `{"path": "status.byId", "outputFormat": "map", "keyBy": "id"}`),
+				step("a", `{"path": "status.byId", "mergeStrategy": "deepMerge", "outputFormat": "map", "keyBy": "id"}`),
+			},
+			want: func(int) string {
+				return `{"byId":{"/a":{"id":"/a"},"/c":{"id":"/c"}}}`
+			},
+		},
+		"DeepMergeIntoOtherFunction": {
+			reason:   "deepMerge should keep what another function wrote during this run when it equals the observed value",
+			earlier:  `{"byId":{"/a":{"id":"/a"},"/c":{"id":"/c"}}}`,
+			pipeline: []string{step("a", `{"path": "status.byId", "mergeStrategy": "deepMerge", "outputFormat": "map", "keyBy": "id"}`)},
+			want: func(int) string {
+				return `{"byId":{"/a":{"id":"/a"},"/c":{"id":"/c"}}}`
+			},
+		},
+		"DeepMerge": {
+			reason:   "deepMerge should drop keys that are no longer returned",
+			pipeline: []string{step("a", `{"path": "status.other"}`), step("b", `{"path": "status.byId", "mergeStrategy": "deepMerge", "outputFormat": "map", "keyBy": "id"}`)},
+			want: func(r int) string {
+				return fmt.Sprintf(`{"other":[{"id":"/a"}],"byId":{"/b%d":{"id":"/b%d"}}}`, r, r)
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			reconcile := 0
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, in *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{Data: rows(in.Query, reconcile)},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+			}
+
+			observed := `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`
+			for reconcile = 1; reconcile <= 3; reconcile++ {
+				desired := &fnv1.State{}
+				if tc.earlier != "" {
+					desired.Composite = &fnv1.Resource{Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":` + tc.earlier + `}`)}
+				}
+				pipelineContext := resource.MustStructJSON(`{}`)
+				for _, input := range tc.pipeline {
+					rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+						Meta:        &fnv1.RequestMeta{Tag: "hello"},
+						Input:       resource.MustStructJSON(input),
+						Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(observed)}},
+						Desired:     desired,
+						Context:     pipelineContext,
+						Credentials: testCredentials(),
+					})
+					if err != nil {
+						t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+					}
+					for _, r := range rsp.GetResults() {
+						if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+							t.Fatalf("%s\nRunFunction(...): unexpected fatal result: %s", tc.reason, r.GetMessage())
+						}
+					}
+					desired, pipelineContext = rsp.GetDesired(), rsp.GetContext()
+				}
+
+				status := desired.GetComposite().GetResource().GetFields()["status"].GetStructValue()
+				if diff := cmp.Diff(resource.MustStructJSON(tc.want(reconcile)), status, protocmp.Transform()); diff != "" {
+					t.Errorf("%s\nreconcile %d: -want status, +got status:\n%s", tc.reason, reconcile, diff)
+				}
+				b, err := protojson.Marshal(desired.GetComposite().GetResource())
+				if err != nil {
+					t.Fatal(err)
+				}
+				observed = string(b)
+			}
+		})
+	}
+}
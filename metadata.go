@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// queryInfoKey is the context key of the queryInfo of a single query.
+type queryInfoKey struct{}
+
+// queryInfo describes a single query: its fingerprint and what only azQuery
+// knows about it, such as the service principal it selected.
+type queryInfo struct {
+	fingerprint string
+	clientID    string
+}
+
+// withQueryInfo returns a context in which azQuery records the queryInfo of
+// the query with the fingerprint.
+func withQueryInfo(ctx context.Context, fingerprint string) (context.Context, *queryInfo) {
+	info := &queryInfo{fingerprint: fingerprint}
+	return context.WithValue(ctx, queryInfoKey{}, info), info
+}
+
+// queryInfoFrom returns the queryInfo the context carries, an empty one if
+// there is none.
+func queryInfoFrom(ctx context.Context) *queryInfo {
+	if info, ok := ctx.Value(queryInfoKey{}).(*queryInfo); ok {
+		return info
+	}
+	return &queryInfo{}
+}
+
+// recordClientID records the client ID of the service principal used for the
+// query, if the context carries a queryInfo.
+func recordClientID(ctx context.Context, clientID string) {
+	queryInfoFrom(ctx).clientID = clientID
+}
+
+// clock returns the current time.
+func (f *Function) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// metadataPath returns where the query metadata is written: the
+// metadataTarget, or next to the first status target. Without status targets
+// it is written to the XR status under the name of the first target, as the
+// context does not survive between reconciles.
+func metadataPath(in *v1beta1.Input) string {
+	if in.MetadataTarget != "" {
+		return in.MetadataTarget
+	}
+	if target, ok := firstStatusTarget(in); ok {
+		return siblingPath(target, "Metadata")
+	}
+	targets := inputTargets(in)
+	if len(targets) == 0 {
+		return ""
+	}
+	parts, err := ParseNestedKey(strings.TrimPrefix(targets[0].Path, "context."))
+	if err != nil {
+		return ""
+	}
+	return "status.[" + parts[len(parts)-1] + "Metadata]"
+}
+
+// intervalSet reports whether queries are limited to one per interval.
+func intervalSet(in *v1beta1.Input) bool {
+	d, err := queryInterval(in)
+	return err == nil && d > 0
+}
+
+// siblingPath appends the suffix to the last key of the path, e.g.
+// status.vnetsMetadata for status.vnets.
+func siblingPath(path, suffix string) string {
+	if strings.HasSuffix(path, "]") {
+		return strings.TrimSuffix(path, "]") + suffix + "]"
+	}
+	return path + suffix
+}
+
+// queryHash identifies the query text, so consumers can tell which query
+// produced the data.
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint identifies the resolved query, the scope it runs against, the
+// credentials it runs with and the options that shape the data written to the
+// targets. Data written with a different fingerprint is stale even if the
+// interval did not pass yet.
+func fingerprint(in *v1beta1.Input, azureCreds interface{}) string {
+	// The query reports a scope that cannot be resolved when it runs
+	subscriptions, _ := resolveScope(in.ScopePolicy, in.Subscriptions, credentialSubscriptions(azureCreds), logging.NewNopLogger())
+
+	// Plain strings, slices and structs, marshalling them cannot fail
+	b, _ := json.Marshal(struct {
+		Query             string                           `json:"query"`
+		ManagementGroups  []*string                        `json:"managementGroups,omitempty"`
+		Subscriptions     []string                         `json:"subscriptions,omitempty"`
+		ProviderConfigRef *v1beta1.ProviderConfigReference `json:"providerConfigRef,omitempty"`
+		IdentityType      v1beta1.IdentityType             `json:"identityType"`
+		ClientIDs         []string                         `json:"clientIds,omitempty"`
+		Targets           []v1beta1.Target                 `json:"targets"`
+		DedupeBy          []string                         `json:"dedupeBy,omitempty"`
+		SortBy            []string                         `json:"sortBy,omitempty"`
+		MaxRows           *int                             `json:"maxRows,omitempty"`
+		MaxBytes          *int                             `json:"maxBytes,omitempty"`
+		OnLimitExceeded   v1beta1.LimitAction              `json:"onLimitExceeded,omitempty"`
+	}{
+		Query:             in.Query,
+		ManagementGroups:  in.ManagementGroups,
+		Subscriptions:     subscriptions,
+		ProviderConfigRef: in.ProviderConfigRef,
+		IdentityType:      identityTypeOf(in),
+		ClientIDs:         credentialClientIDs(azureCreds),
+		Targets:           inputTargets(in),
+		DedupeBy:          in.DedupeBy,
+		SortBy:            in.SortBy,
+		MaxRows:           in.MaxRows,
+		MaxBytes:          in.MaxBytes,
+		OnLimitExceeded:   in.OnLimitExceeded,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// queryMetadata describes the query that produced the rows written to the
+// targets.
+func (f *Function) queryMetadata(in *v1beta1.Input, results armresourcegraph.ClientResourcesResponse, rows interface{}, truncated bool, info *queryInfo) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"lastQueryTime": f.clock().Format(time.RFC3339),
+		"queryHash":     queryHash(in.Query),
+		"fingerprint":   info.fingerprint,
+		"truncated":     truncated,
+	}
+	if r, ok := rows.([]interface{}); ok {
+		metadata["rowCount"] = len(r)
+	} else if results.Count != nil {
+		metadata["rowCount"] = *results.Count
+	}
+	if results.TotalRecords != nil {
+		metadata["totalRecords"] = *results.TotalRecords
+	}
+	if info.clientID != "" {
+		metadata["clientId"] = info.clientID
+	}
+	return metadata
+}
+
+// putQueryMetadata writes the query metadata when it is asked for, needed to
+// decide whether to skip later queries, reports a truncation or was written
+// before, so an earlier truncated flag is cleared.
+func (f *Function) putQueryMetadata(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, metadata map[string]interface{}) error {
+	path := metadataPath(in)
+	if !metadataRequired(in, metadata) {
+		existing, err := currentTargetValue(rsp, path)
+		if err != nil || existing == nil {
+			return err
+		}
+	}
+	if scheduled(in) {
+		if err := f.cacheContextTargets(rsp, in, metadata); err != nil {
+			return err
+		}
+	}
+	return f.putQueryResult(rsp, path, metadata)
+}
+
+// cacheContextTargets adds the values of the context targets to the query
+// metadata, so skipped queries can restore them. Together the cached values
+// may not exceed maxBytes, otherwise nothing is cached in the XR status and
+// skipped queries query again.
+func (f *Function) cacheContextTargets(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, metadata map[string]interface{}) error {
+	cache, err := contextCache(rsp, in)
+	if err != nil || len(cache) == 0 {
+		return err
+	}
+	if maxBytes := f.resultLimits(in).maxBytes; maxBytes > 0 {
+		size := 0
+		for _, v := range cache {
+			n, err := jsonSize(v)
+			if err != nil {
+				return err
+			}
+			size += n
+		}
+		if size > maxBytes {
+			response.Warning(rsp, errors.Errorf("context targets of %d bytes exceed maxBytes %d, not caching them in the query metadata; skipped queries run again instead", size, maxBytes))
+			return nil
+		}
+	}
+	metadata["cache"] = cache
+	return nil
+}
+
+// metadataRequired reports whether the query metadata is written even if there
+// was none before.
+func metadataRequired(in *v1beta1.Input, metadata map[string]interface{}) bool {
+	truncated, _ := metadata["truncated"].(bool)
+	_, refreshed := metadata["refreshRequestedAt"]
+	return in.MetadataTarget != "" || scheduled(in) || skipWhenHasData(in) || in.Notify != nil || truncated || refreshed
+}
+
+// updateQueryMetadata adds the fields to the query metadata, keeping what the
+// last successful query recorded. The next successful query drops them.
+func (f *Function) updateQueryMetadata(req *fnv1.RunFunctionRequest, in *v1beta1.Input, fields map[string]interface{}, rsp *fnv1.RunFunctionResponse) error {
+	path := metadataPath(in)
+	if path == "" {
+		return nil
+	}
+	existing, err := currentTargetValue(rsp, path)
+	if err != nil {
+		return err
+	}
+	metadata, ok := existing.(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+	}
+	for k, v := range fields {
+		metadata[k] = v
+	}
+	if err := f.putQueryResult(rsp, path, metadata); err != nil {
+		return err
+	}
+	return recordWrittenStatus(req, rsp, path)
+}
+
+// contextCache returns the values written to the context targets keyed by
+// their path, so they can be restored when a later query is skipped.
+func contextCache(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input) (map[string]interface{}, error) {
+	cache := map[string]interface{}{}
+	for _, t := range inputTargets(in) {
+		if !strings.HasPrefix(t.Path, "context.") {
+			continue
+		}
+		v, err := currentTargetValue(rsp, t.Path)
+		if err != nil {
+			return nil, err
+		}
+		cache[t.Path] = v
+	}
+	return cache, nil
+}
+
+// restoreContextTargets writes the values cached in the query metadata to the
+// context targets. It fails if any of them is not cached.
+func (f *Function) restoreContextTargets(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	for _, t := range inputTargets(in) {
+		if err := f.restoreContextTarget(req, in, t.Path, rsp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreContextTarget writes the value cached in the query metadata to a
+// context target. Status targets keep their value in the desired XR.
+func (f *Function) restoreContextTarget(req *fnv1.RunFunctionRequest, in *v1beta1.Input, path string, rsp *fnv1.RunFunctionResponse) error {
+	if !strings.HasPrefix(path, "context.") {
+		return nil
+	}
+	var cache map[string]interface{}
+	if metadata, err := f.getTargetData(req, metadataPath(in)); err == nil {
+		if m, ok := metadata.(map[string]interface{}); ok {
+			cache, _ = m["cache"].(map[string]interface{})
+		}
+	}
+	v, ok := cache[path]
+	if !ok {
+		return errors.Errorf("no cached data for target %s", path)
+	}
+	return putQueryResultToContext(rsp, path, v, f)
+}
+
+// queryChanged reports whether the data in the targets was written with a
+// different fingerprint, going by the query metadata in the XR status. Data
+// without a fingerprint is taken as current.
+func (f *Function) queryChanged(req *fnv1.RunFunctionRequest, in *v1beta1.Input, info *queryInfo) bool {
+	path := metadataPath(in)
+	if !strings.HasPrefix(path, "status.") {
+		return false
+	}
+	metadata, err := f.getTargetData(req, path)
+	if err != nil {
+		return false
+	}
+	m, _ := metadata.(map[string]interface{})
+	stored, ok := m["fingerprint"].(string)
+	return ok && stored != info.fingerprint
+}
+
+// currentTargetValue returns the value at the target path in the desired XR
+// or context of the response, nil if there is none.
+func currentTargetValue(rsp *fnv1.RunFunctionResponse, path string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(path, "status."):
+		xrStatus, _, err := desiredXRAndStatus(rsp)
+		if err != nil {
+			return nil, err
+		}
+		v, _ := getNestedValue(xrStatus, strings.TrimPrefix(path, "status."))
+		return v, nil
+	case strings.HasPrefix(path, "context."):
+		v, _ := getNestedValue(rsp.GetContext().AsMap(), strings.TrimPrefix(path, "context."))
+		return v, nil
+	default:
+		return nil, errors.Errorf("Unrecognized target field: %s", path)
+	}
+}
+
+// withoutLastQueryTime drops the timestamp row or field earlier versions
+// appended to a status target, so merging into the target does not keep it
+// now that the time is in the query metadata.
+func withoutLastQueryTime(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		rows := make([]interface{}, 0, len(v))
+		for _, row := range v {
+			if m, ok := row.(map[string]interface{}); ok && len(m) == 1 && m["lastQueryTime"] != nil {
+				continue
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	case map[string]interface{}:
+		if _, ok := v["lastQueryTime"]; !ok {
+			return v
+		}
+		fields := make(map[string]interface{}, len(v))
+		for k, f := range v {
+			if k != "lastQueryTime" {
+				fields[k] = f
+			}
+		}
+		return fields
+	default:
+		return v
+	}
+}
+
+// lastQueryTime reads the time of the last query from the query metadata. It
+// falls back to the timestamp row or field earlier versions appended to the
+// first status target, which is dropped the next time the target is written.
+func (f *Function) lastQueryTime(req *fnv1.RunFunctionRequest, in *v1beta1.Input) (time.Time, error) {
+	if path := metadataPath(in); strings.HasPrefix(path, "status.") {
+		if metadata, err := f.getTargetData(req, path); err == nil {
+			if m, ok := metadata.(map[string]interface{}); ok {
+				return f.extractLastQueryTimeFromMap(m)
+			}
+		}
+	}
+
+	target, ok := firstStatusTarget(in)
+	if !ok {
+		return time.Time{}, errors.New("no status target")
+	}
+	targetData, err := f.getTargetData(req, target)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return f.extractLastQueryTime(targetData)
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+// inputFingerprint returns the fingerprint of the Input in JSON run with the
+// credentials most tests use.
+func inputFingerprint(t *testing.T, input string) string {
+	t.Helper()
+	in := &v1beta1.Input{}
+	if err := json.Unmarshal([]byte(input), in); err != nil {
+		t.Fatalf("json.Unmarshal(...): %v", err)
+	}
+	return fingerprint(in, map[string]string{ClientID: "test-client-id", SubscriptionID: "test-subscription-id"})
+}
+
+func TestFingerprint(t *testing.T) {
+	in := func(mutate func(in *v1beta1.Input)) *v1beta1.Input {
+		in := &v1beta1.Input{Query: "Resources| count", Target: "status.vnets", Subscriptions: []*string{to.Ptr(sub1)}}
+		if mutate != nil {
+			mutate(in)
+		}
+		return in
+	}
+	intersect := func(in *v1beta1.Input) { in.ScopePolicy = v1beta1.ScopePolicyIntersect }
+	creds := func(clientID string, subscriptions ...string) []map[string]string {
+		c := []map[string]string{}
+		for _, sub := range subscriptions {
+			c = append(c, map[string]string{ClientID: clientID, SubscriptionID: sub})
+		}
+		return c
+	}
+
+	type run struct {
+		in    *v1beta1.Input
+		creds interface{}
+	}
+
+	cases := map[string]struct {
+		reason string
+		a, b   run
+		same   bool
+	}{
+		"Same": {
+			reason: "The same Input and credentials should have the same fingerprint",
+			a:      run{in: in(nil), creds: creds("client", sub1)},
+			b:      run{in: in(nil), creds: creds("client", sub1)},
+			same:   true,
+		},
+		"CredentialSubscriptions": {
+			reason: "Another subscription in the credentials should change the fingerprint when it widens the scope",
+			a:      run{in: in(func(in *v1beta1.Input) { in.ScopePolicy = v1beta1.ScopePolicyUnion }), creds: creds("client", sub1)},
+			b:      run{in: in(func(in *v1beta1.Input) { in.ScopePolicy = v1beta1.ScopePolicyUnion }), creds: creds("client", sub1, sub2)},
+		},
+		"CredentialSubscriptionsWithoutInput": {
+			reason: "Other subscriptions in the credentials should change the fingerprint when the Input has none",
+			a:      run{in: in(func(in *v1beta1.Input) { in.Subscriptions = nil }), creds: map[string]string{ClientID: "client", SubscriptionID: sub1}},
+			b:      run{in: in(func(in *v1beta1.Input) { in.Subscriptions = nil }), creds: map[string]string{ClientID: "client", SubscriptionID: sub2}},
+		},
+		"IntersectedScope": {
+			reason: "Credential subscriptions the intersection drops should not change the fingerprint",
+			a:      run{in: in(intersect), creds: creds("client", sub1, sub2)},
+			b:      run{in: in(intersect), creds: creds("client", sub1, sub3)},
+			same:   true,
+		},
+		"ClientID": {
+			reason: "Another service principal should change the fingerprint",
+			a:      run{in: in(nil), creds: creds("client", sub1)},
+			b:      run{in: in(nil), creds: creds("other-client", sub1)},
+		},
+		"ProviderConfigRef": {
+			reason: "Another ProviderConfig should change the fingerprint",
+			a:      run{in: in(func(in *v1beta1.Input) { in.ProviderConfigRef = &v1beta1.ProviderConfigReference{Name: "a"} }), creds: creds("client", sub1)},
+			b:      run{in: in(func(in *v1beta1.Input) { in.ProviderConfigRef = &v1beta1.ProviderConfigReference{Name: "b"} }), creds: creds("client", sub1)},
+		},
+		"Identity": {
+			reason: "Another identity type should change the fingerprint",
+			a:      run{in: in(nil), creds: creds("client", sub1)},
+			b: run{in: in(func(in *v1beta1.Input) {
+				in.Identity = &v1beta1.Identity{Type: v1beta1.IdentityTypeAzureWorkloadIdentityCredentials}
+			}), creds: creds("client", sub1)},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, b := fingerprint(tc.a.in, tc.a.creds), fingerprint(tc.b.in, tc.b.creds)
+			if diff := cmp.Diff(tc.same, a == b); diff != "" {
+				t.Errorf("%s\nfingerprint(...): -want same, +got same:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestQueryMetadata(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	// $fingerprint in the XR and the wanted status and context stands for the
+	// fingerprint of the Input
+	type args struct {
+		input string
+		xr    string
+		// desired is the desired XR earlier pipeline steps handed over, if any
+		desired string
+	}
+	type want struct {
+		status  string
+		context string
+		results []*fnv1.Result
+		skipped bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"MetadataTarget": {
+			reason: "The metadata should hold the row count, the total records and the client ID of the service principal used",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"metadataTarget": "context.[azure.query]"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"},{"id":"/b"}]}`,
+				context: `{"azure.query":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 2,
+					"totalRecords": 5,
+					"truncated": false,
+					"clientId": "selected-client-id"
+				}}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"LegacyTimestampRow": {
+			reason: "The interval should still honor the timestamp row earlier versions appended to the target",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/a"},{"lastQueryTime":"` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"}]}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/a"},{"lastQueryTime":"` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				skipped: true,
+			},
+		},
+		"LegacyTimestampRowMigrated": {
+			reason: "Merging into a target should drop the timestamp row earlier versions appended and write the metadata instead",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"mergeStrategy": "append",
+					"queryIntervalMinutes": 10
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"},{"lastQueryTime":"` + now.Add(-15*time.Minute).Format(time.RFC3339) + `"}]}}`,
+				desired: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"},{"lastQueryTime":"` + now.Add(-15*time.Minute).Format(time.RFC3339) + `"}]}}`,
+			},
+			want: want{
+				status: `{
+					"vnets": [{"id":"/old"},{"id":"/a"},{"id":"/b"}],
+					"vnetsMetadata": {
+						"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+						"queryHash": "` + queryHash("Resources| count") + `",
+						"fingerprint": "$fingerprint",
+						"rowCount": 2,
+						"totalRecords": 5,
+						"truncated": false,
+						"clientId": "selected-client-id"
+					}
+				}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"ContextTargetRestoredWithinInterval": {
+			reason: "Within the interval the context targets should be restored from the cache in the XR status",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.[apiextensions.crossplane.io/environment].vnets",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `",
+					"cache": {"context.[apiextensions.crossplane.io/environment].vnets": [{"id":"/cached"}]}
+				}}}`,
+			},
+			want: want{
+				status: `{"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `",
+					"cache": {"context.[apiextensions.crossplane.io/environment].vnets": [{"id":"/cached"}]}
+				}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]},"apiextensions.crossplane.io/environment":{"vnets":[{"id":"/cached"}]}}`,
+				skipped: true,
+			},
+		},
+		"ContextTargetNotCached": {
+			reason: "Within the interval the query should run if a context target is not cached",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"targets": [{"path": "status.vnets"}, {"path": "context.vnets"}],
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `"
+				}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"},{"id":"/b"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 2,
+					"totalRecords": 5,
+					"truncated": false,
+					"clientId": "selected-client-id",
+					"cache": {"context.vnets": [{"id":"/a"},{"id":"/b"}]}
+				}}`,
+				context: `{"vnets":[{"id":"/a"},{"id":"/b"}]}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"QueryChangedWithinInterval": {
+			reason: "Within the interval the query should run if the data was written with another fingerprint",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `",
+					"fingerprint": "of-an-older-query"
+				}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"},{"id":"/b"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 2,
+					"totalRecords": 5,
+					"truncated": false,
+					"clientId": "selected-client-id"
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"CredentialScopeChangedWithinInterval": {
+			reason: "Within the interval the query should run if only the subscriptions of the credentials changed",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-5*time.Minute).Format(time.RFC3339) + `",
+					"fingerprint": "` + fingerprint(&v1beta1.Input{Query: "Resources| count", Target: "status.vnets"},
+					map[string]string{ClientID: "test-client-id", SubscriptionID: sub2}) + `"
+				}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"},{"id":"/b"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 2,
+					"totalRecords": 5,
+					"truncated": false,
+					"clientId": "selected-client-id"
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"QueryChangedWhenTargetHasData": {
+			reason: "skipQueryWhenTargetHasData should query again if the data was written with another fingerprint",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"skipQueryWhenTargetHasData": true
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"fingerprint": "of-an-older-query"
+				}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/a"},{"id":"/b"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "$fingerprint",
+					"rowCount": 2,
+					"totalRecords": 5,
+					"truncated": false,
+					"clientId": "selected-client-id"
+				}}`,
+				context: `{}`,
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"QueryUnchangedWhenTargetHasData": {
+			reason: "skipQueryWhenTargetHasData should skip the query if the data was written with the same fingerprint",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"skipQueryWhenTargetHasData": true
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"fingerprint": "$fingerprint"
+				}}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}],"vnetsMetadata":{"fingerprint":"$fingerprint"}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets","vnetsMetadata"]}}`,
+				skipped: true,
+			},
+		},
+		"ContextMetadataTargetWithInterval": {
+			reason: "The interval state should not be kept in the context, which does not survive between reconciles",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.vnets",
+					"metadataTarget": "context.vnetsMetadata",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "metadataTarget context.vnetsMetadata must be a status field when a query interval or schedule is set",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"InvalidMetadataTarget": {
+			reason: "metadataTarget should be a status or context field",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"metadataTarget": "spec.query"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "metadataTarget spec.query must be a status or context field other than the targets",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"MetadataTargetIsATarget": {
+			reason: "metadataTarget should not overwrite a target",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"metadataTarget": "status.vnets"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "metadataTarget status.vnets must be a status or context field other than the targets",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(ctx context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						recordClientID(ctx, "selected-client-id")
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{
+								Data: []interface{}{
+									map[string]interface{}{"id": "/a"},
+									map[string]interface{}{"id": "/b"},
+								},
+								TotalRecords: to.Ptr(int64(5)),
+							},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+
+			req := reconcile(tc.args.input, strings.ReplaceAll(tc.args.xr, "$fingerprint", inputFingerprint(t, tc.args.input)))
+			if tc.args.desired != "" {
+				req.Desired = &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.args.desired)}}
+			}
+			rsp, err := f.RunFunction(context.Background(), req)
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			skipped := false
+			for _, c := range rsp.GetConditions() {
+				skipped = skipped || c.GetType() == "FunctionSkip"
+			}
+			if diff := cmp.Diff(tc.want.skipped, skipped); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want skipped, +got skipped:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			want := strings.ReplaceAll(tc.want.status, "$fingerprint", inputFingerprint(t, tc.args.input))
+			if diff := cmp.Diff(resource.MustStructJSON(want), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			want = strings.ReplaceAll(tc.want.context, "$fingerprint", inputFingerprint(t, tc.args.input))
+			if diff := cmp.Diff(resource.MustStructJSON(want), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+const (
+	// EventTypeResultChanged is the CloudEvents type of a changed target
+	EventTypeResultChanged = "io.crossplane.fn.azresourcegraph.result.changed"
+	// SignatureHeader carries the hex HMAC-SHA256 of the event body, prefixed
+	// with sha256=
+	SignatureHeader = "X-Signature-256"
+
+	defaultNotifyTimeout    = 5 * time.Second
+	defaultNotifyRetries    = 2
+	defaultNotifyBackoff    = time.Second
+	defaultNotifyBudget     = 10 * time.Second
+	defaultSigningSecretKey = "secret"
+
+	// Bounds of the Notify timeoutSeconds and maxRetries, matching the CRD
+	minNotifyTimeoutSeconds = 1
+	maxNotifyTimeoutSeconds = 30
+	maxNotifyRetries        = 5
+)
+
+// NotifierInterface defines the methods required for publishing change events.
+type NotifierInterface interface {
+	notify(ctx context.Context, d delivery) error
+}
+
+// delivery is an event to publish and where to.
+type delivery struct {
+	url        string
+	secret     []byte
+	timeout    time.Duration
+	maxRetries int
+	event      cloudEvent
+}
+
+// cloudEvent is a CloudEvent in the structured JSON content mode.
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            changeEvent `json:"data"`
+}
+
+// changeEvent is the data of a changed target event.
+type changeEvent struct {
+	Fingerprint         string       `json:"fingerprint"`
+	QueryHash           string       `json:"queryHash"`
+	Composite           compositeRef `json:"composite"`
+	Target              string       `json:"target"`
+	ContentHash         string       `json:"contentHash"`
+	PreviousContentHash string       `json:"previousContentHash,omitempty"`
+	RowCount            *int         `json:"rowCount,omitempty"`
+	Diff                *diffSummary `json:"diff,omitempty"`
+}
+
+// compositeRef identifies the XR whose target changed.
+type compositeRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// diffSummary is the keys of the rows added, removed or changed, when the
+// Input diffs the target.
+type diffSummary struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// HTTPNotifier POSTs events to HTTP endpoints. Deliveries that fail with a
+// network error, a timeout, 408, 429 or a 5xx status are retried with an
+// exponential backoff.
+type HTTPNotifier struct {
+	client *http.Client
+	// backoff is the wait before the first retry, doubled for each retry
+	backoff time.Duration
+}
+
+// notify delivers the event, retrying failed attempts.
+func (n *HTTPNotifier) notify(ctx context.Context, d delivery) error {
+	body, err := json.Marshal(d.event)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal event")
+	}
+
+	wait := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, d, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= d.maxRetries {
+			return errors.Wrapf(err, "cannot deliver event after %d attempts", attempt+1)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "cannot deliver event")
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether it may be retried.
+func (n *HTTPNotifier) post(ctx context.Context, d delivery, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+sign(d.secret, body))
+	}
+
+	client := n.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close() //nolint:errcheck // nothing to do about it
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return retry, errors.Errorf("endpoint responded %s", resp.Status)
+}
+
+// sign returns the hex HMAC-SHA256 of the body.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// eventNotifier returns the notifier of the Function, an HTTPNotifier if nil.
+// Redirects are not followed, so they cannot lead outside the notify URLs the
+// policy allows.
+func (f *Function) eventNotifier() NotifierInterface {
+	if f.notifier != nil {
+		return f.notifier
+	}
+	return &HTTPNotifier{client: &http.Client{CheckRedirect: noRedirects}, backoff: defaultNotifyBackoff}
+}
+
+// noRedirects makes an http.Client return redirect responses as they are.
+func noRedirects(_ *http.Request, _ []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// deliveryBudget returns how long the deliveries of a single call may
+// take together.
+func (f *Function) deliveryBudget() time.Duration {
+	if f.notifyBudget > 0 {
+		return f.notifyBudget
+	}
+	return defaultNotifyBudget
+}
+
+// validateNotify checks that the content hashes are kept in the XR status,
+// so changes can be told across reconciles, the bounds of the timeout and
+// retries, which Crossplane does not enforce on the Input, and that the
+// signing secret is passed to the function.
+func (f *Function) validateNotify(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	if in.Notify == nil {
+		return nil
+	}
+
+	var err error
+	switch {
+	case !strings.HasPrefix(in.Notify.URL, "http://") && !strings.HasPrefix(in.Notify.URL, "https://"):
+		err = errors.Errorf("notify url %q must be an http or https URL", in.Notify.URL)
+	case !strings.HasPrefix(metadataPath(in), "status."):
+		err = errors.New("notify requires the query metadata to be written to the XR status")
+	default:
+		err = f.policy.CheckNotifyURL(in.Notify.URL)
+		if err == nil {
+			err = validateDeliveryBounds(in.Notify)
+		}
+		if err == nil {
+			_, err = signingSecret(req, in.Notify.SigningSecretRef)
+		}
+	}
+	if err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+	return nil
+}
+
+// validateDeliveryBounds checks that the timeout and the retries of a single
+// delivery are within the bounds.
+func validateDeliveryBounds(n *v1beta1.Notify) error {
+	if t := n.TimeoutSeconds; t != nil && (*t < minNotifyTimeoutSeconds || *t > maxNotifyTimeoutSeconds) {
+		return errors.Errorf("notify timeoutSeconds %d must be between %d and %d", *t, minNotifyTimeoutSeconds, maxNotifyTimeoutSeconds)
+	}
+	if r := n.MaxRetries; r != nil && (*r < 0 || *r > maxNotifyRetries) {
+		return errors.Errorf("notify maxRetries %d must be between 0 and %d", *r, maxNotifyRetries)
+	}
+	return nil
+}
+
+// signingSecret returns the secret events are signed with, nil without a
+// reference.
+func signingSecret(req *fnv1.RunFunctionRequest, ref *v1beta1.SigningSecretRef) ([]byte, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	key := ref.Key
+	if key == "" {
+		key = defaultSigningSecretKey
+	}
+	creds, ok := req.GetCredentials()[ref.Name]
+	if !ok {
+		return nil, errors.Errorf("cannot get %s credentials for the notify signing secret", ref.Name)
+	}
+	secret := creds.GetCredentialData().GetData()[key]
+	if len(secret) == 0 {
+		return nil, errors.Errorf("%s credentials have no %q key", ref.Name, key)
+	}
+	return secret, nil
+}
+
+// contentHash identifies the value of a target. Maps are marshalled with
+// sorted keys, so equal values have equal hashes.
+func contentHash(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// targetContentHashes returns the content hash of every target.
+func targetContentHashes(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) map[string]interface{} {
+	hashes := map[string]interface{}{}
+	for _, t := range inputTargets(in) {
+		if v, err := currentTargetValue(rsp, t.Path); err == nil {
+			hashes[t.Path] = contentHash(v)
+		}
+	}
+	return hashes
+}
+
+// recordedContentHashes returns the content hashes in the query metadata.
+func recordedContentHashes(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) map[string]interface{} {
+	if in.Notify == nil {
+		return nil
+	}
+	metadata, _ := currentTargetValue(rsp, metadataPath(in))
+	m, _ := metadata.(map[string]interface{})
+	hashes, _ := m["contentHashes"].(map[string]interface{})
+	return hashes
+}
+
+// notifyChanges publishes an event for every target whose content hash
+// changed. A target that was never hashed counts as changed. All deliveries
+// share the notify budget, so a slow endpoint cannot hold up the pipeline.
+// Failed deliveries emit a Warning and keep the previous hash, so they are
+// retried by the next reconcile.
+func (f *Function) notifyChanges(ctx context.Context, req *fnv1.RunFunctionRequest, in *v1beta1.Input, previous map[string]interface{}, baseline interface{}, rsp *fnv1.RunFunctionResponse) {
+	ctx, cancel := context.WithTimeout(ctx, f.deliveryBudget())
+	defer cancel()
+
+	current := recordedContentHashes(in, rsp)
+	failed := false
+	for _, t := range inputTargets(in) {
+		hash, _ := current[t.Path].(string)
+		prev, _ := previous[t.Path].(string)
+		if hash == "" || hash == prev {
+			continue
+		}
+		if err := f.notifyChange(ctx, req, in, t.Path, hash, prev, baseline, rsp); err != nil {
+			response.Warning(rsp, errors.Wrapf(err, "cannot notify the change of %s", t.Path))
+			restoreHash(current, t.Path, prev)
+			failed = true
+			continue
+		}
+		f.log.Info("Notified result change", "target", t.Path)
+	}
+	if !failed {
+		return
+	}
+	if err := f.updateQueryMetadata(req, in, map[string]interface{}{"contentHashes": current}, rsp); err != nil {
+		f.log.Debug("Cannot keep the content hashes of failed notifications", "error", err)
+	}
+}
+
+// restoreHash sets the hash of the target back to the previous one.
+func restoreHash(hashes map[string]interface{}, path, prev string) {
+	if prev == "" {
+		delete(hashes, path)
+		return
+	}
+	hashes[path] = prev
+}
+
+// notifyChange publishes the event of a changed target.
+func (f *Function) notifyChange(ctx context.Context, req *fnv1.RunFunctionRequest, in *v1beta1.Input, path, hash, prev string, baseline interface{}, rsp *fnv1.RunFunctionResponse) error {
+	secret, err := signingSecret(req, in.Notify.SigningSecretRef)
+	if err != nil {
+		return err
+	}
+	event, err := f.changeEvent(req, in, path, queryInfoFrom(ctx).fingerprint, rsp)
+	if err != nil {
+		return err
+	}
+	event.Data.ContentHash = hash
+	event.Data.PreviousContentHash = prev
+	if in.Diff != nil && diffOf(in) == path && baseline != nil {
+		event.Data.Diff = summarizeDiff(in, baseline, rsp)
+	}
+
+	d := delivery{
+		url:        in.Notify.URL,
+		secret:     secret,
+		timeout:    defaultNotifyTimeout,
+		maxRetries: defaultNotifyRetries,
+		event:      event,
+	}
+	if in.Notify.TimeoutSeconds != nil {
+		d.timeout = time.Duration(*in.Notify.TimeoutSeconds) * time.Second
+	}
+	if in.Notify.MaxRetries != nil {
+		d.maxRetries = *in.Notify.MaxRetries
+	}
+	return f.eventNotifier().notify(ctx, d)
+}
+
+// changeEvent returns the event of a changed target, without its hashes.
+func (f *Function) changeEvent(req *fnv1.RunFunctionRequest, in *v1beta1.Input, path, fingerprint string, rsp *fnv1.RunFunctionResponse) (cloudEvent, error) {
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		return cloudEvent{}, errors.Wrap(err, "cannot get observed composite resource")
+	}
+	xr := compositeRef{
+		APIVersion: oxr.Resource.GetAPIVersion(),
+		Kind:       oxr.Resource.GetKind(),
+		Name:       oxr.Resource.GetName(),
+		Namespace:  oxr.Resource.GetNamespace(),
+		UID:        string(oxr.Resource.GetUID()),
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return cloudEvent{}, errors.Wrap(err, "cannot generate event id")
+	}
+
+	data := changeEvent{
+		Fingerprint: fingerprint,
+		QueryHash:   queryHash(in.Query),
+		Composite:   xr,
+		Target:      path,
+	}
+	if v, err := currentTargetValue(rsp, path); err == nil {
+		if rows, ok := v.([]interface{}); ok {
+			n := len(rows)
+			data.RowCount = &n
+		}
+	}
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          compositeSource(xr),
+		Type:            EventTypeResultChanged,
+		Subject:         path,
+		Time:            f.clock().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// compositeSource returns the CloudEvents source of the XR, e.g.
+// /example.org/v1/XNetwork/default/my-network.
+func compositeSource(xr compositeRef) string {
+	parts := []string{"", xr.APIVersion, xr.Kind}
+	if xr.Namespace != "" {
+		parts = append(parts, xr.Namespace)
+	}
+	return strings.Join(append(parts, xr.Name), "/")
+}
+
+// summarizeDiff compares the rows of the diffed target with the baseline.
+func summarizeDiff(in *v1beta1.Input, baseline interface{}, rsp *fnv1.RunFunctionResponse) *diffSummary {
+	current, err := currentTargetValue(rsp, diffOf(in))
+	if err != nil {
+		return nil
+	}
+	d, err := diffRows(in.Diff.KeyColumn, baseline, current)
+	if err != nil {
+		return nil
+	}
+	s := &diffSummary{Added: d.added, Removed: d.removed, Changed: d.changed}
+	for _, keys := range []*[]string{&s.Added, &s.Removed, &s.Changed} {
+		if *keys == nil {
+			*keys = []string{}
+		}
+	}
+	return s
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+// eventSink is an HTTP endpoint that records the events it receives and
+// responds with the given statuses, repeating the last one.
+type eventSink struct {
+	mu       sync.Mutex
+	statuses []int
+	delay    time.Duration
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (s *eventSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.bodies = append(s.bodies, body)
+	s.headers = append(s.headers, r.Header.Clone())
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status = s.statuses[min(len(s.bodies), len(s.statuses))-1]
+	}
+	s.mu.Unlock()
+	select {
+	case <-time.After(s.delay):
+	case <-r.Context().Done():
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// events returns the events received by the sink, without their random IDs.
+func (s *eventSink) events(t *testing.T) []cloudEvent {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []cloudEvent
+	for _, b := range s.bodies {
+		e := cloudEvent{}
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatalf("cannot unmarshal event %s: %v", b, err)
+		}
+		e.ID = ""
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestHTTPNotifier(t *testing.T) {
+	secret := []byte("s3cr3t")
+
+	type args struct {
+		statuses   []int
+		delay      time.Duration
+		maxRetries int
+		secret     []byte
+	}
+	type want struct {
+		attempts int
+		err      error
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Delivered": {
+			reason: "An accepted event should be delivered once",
+			args:   args{statuses: []int{http.StatusAccepted}, maxRetries: 2, secret: secret},
+			want:   want{attempts: 1},
+		},
+		"RetriedServerError": {
+			reason: "A server error should be retried",
+			args:   args{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, maxRetries: 2},
+			want:   want{attempts: 3},
+		},
+		"RetriesExhausted": {
+			reason: "Delivery should fail once the retries are exhausted",
+			args:   args{statuses: []int{http.StatusServiceUnavailable}, maxRetries: 1},
+			want:   want{attempts: 2, err: cmpopts.AnyError},
+		},
+		"ClientErrorNotRetried": {
+			reason: "A client error should fail without retries",
+			args:   args{statuses: []int{http.StatusBadRequest}, maxRetries: 2},
+			want:   want{attempts: 1, err: cmpopts.AnyError},
+		},
+		"Timeout": {
+			reason: "An attempt slower than the timeout should fail and be retried",
+			args:   args{delay: 200 * time.Millisecond, maxRetries: 1},
+			want:   want{attempts: 2, err: cmpopts.AnyError},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sink := &eventSink{statuses: tc.args.statuses, delay: tc.args.delay}
+			srv := httptest.NewServer(sink)
+			defer srv.Close()
+
+			event := cloudEvent{SpecVersion: "1.0", ID: "42", Source: "/example.org/v1/XR/cool-xr", Type: EventTypeResultChanged}
+			n := &HTTPNotifier{client: srv.Client(), backoff: time.Millisecond}
+			err := n.notify(context.Background(), delivery{
+				url:        srv.URL,
+				secret:     tc.args.secret,
+				timeout:    50 * time.Millisecond,
+				maxRetries: tc.args.maxRetries,
+				event:      event,
+			})
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nnotify(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.attempts, len(sink.bodies)); diff != "" {
+				t.Errorf("%s\nnotify(...): -want attempts, +got attempts:\n%s", tc.reason, diff)
+			}
+
+			h := sink.headers[0]
+			if diff := cmp.Diff("application/cloudevents+json; charset=utf-8", h.Get("Content-Type")); diff != "" {
+				t.Errorf("%s\nnotify(...): -want content type, +got content type:\n%s", tc.reason, diff)
+			}
+			wantSignature := ""
+			if tc.args.secret != nil {
+				mac := hmac.New(sha256.New, tc.args.secret)
+				mac.Write(sink.bodies[0])
+				wantSignature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			}
+			if diff := cmp.Diff(wantSignature, h.Get(SignatureHeader)); diff != "" {
+				t.Errorf("%s\nnotify(...): -want signature, +got signature:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	rows := []interface{}{map[string]interface{}{"id": "/a"}}
+	hash := contentHash(rows)
+	oldHash := contentHash([]interface{}{map[string]interface{}{"id": "/old"}})
+	input := func(extra string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "Resources| count",
+			"target": "status.vnets",
+			"notify": {"url": "$url", "maxRetries": 0` + extra + `}
+		}`
+	}
+	xr := func(status string) string {
+		return `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","uid":"1234"},"status":` + status + `}`
+	}
+	event := func(prev string, diff *diffSummary) cloudEvent {
+		one := 1
+		return cloudEvent{
+			SpecVersion:     "1.0",
+			Source:          "/example.org/v1/XR/cool-xr",
+			Type:            EventTypeResultChanged,
+			Subject:         "status.vnets",
+			Time:            now.Format(time.RFC3339),
+			DataContentType: "application/json",
+			Data: changeEvent{
+				QueryHash:           queryHash("Resources| count"),
+				Composite:           compositeRef{APIVersion: "example.org/v1", Kind: "XR", Name: "cool-xr", UID: "1234"},
+				Target:              "status.vnets",
+				ContentHash:         hash,
+				PreviousContentHash: prev,
+				RowCount:            &one,
+				Diff:                diff,
+			},
+		}
+	}
+
+	type args struct {
+		input    string
+		xr       string
+		statuses []int
+		// delay is how long the endpoint takes to respond
+		delay time.Duration
+		// budget is the notify budget of the function, which RunFunction
+		// must return within
+		budget time.Duration
+		creds  map[string]*fnv1.Credentials
+		policy *Policy
+	}
+	type want struct {
+		events  []cloudEvent
+		hashes  map[string]interface{}
+		results []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"FirstQuery": {
+			reason: "A target that was never hashed should be notified and its hash recorded",
+			args: args{
+				input: input(""),
+				xr:    xr(`{}`),
+			},
+			want: want{
+				events:  []cloudEvent{event("", nil)},
+				hashes:  map[string]interface{}{"status.vnets": hash},
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"Unchanged": {
+			reason: "A target whose hash did not change should not be notified",
+			args: args{
+				input: input(""),
+				xr:    xr(`{"vnets":[{"id":"/a"}],"vnetsMetadata":{"contentHashes":{"status.vnets":"` + hash + `"}}}`),
+			},
+			want: want{
+				hashes:  map[string]interface{}{"status.vnets": hash},
+				results: []*fnv1.Result{queried},
+			},
+		},
+		"ChangedWithDiff": {
+			reason: "A changed target should be notified with the diff summary",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"diff": {"keyColumn": "id", "target": "status.vnetsDiff"},
+					"notify": {"url": "$url", "signingSecretRef": {"name": "webhook"}}
+				}`,
+				xr: xr(`{"vnets":[{"id":"/old"}],"vnetsMetadata":{"contentHashes":{"status.vnets":"` + oldHash + `"}}}`),
+				creds: map[string]*fnv1.Credentials{"webhook": {Source: &fnv1.Credentials_CredentialData{CredentialData: &fnv1.CredentialData{
+					Data: map[string][]byte{"secret": []byte("s3cr3t")},
+				}}}},
+			},
+			want: want{
+				events: []cloudEvent{event(oldHash, &diffSummary{Added: []string{"/a"}, Removed: []string{"/old"}, Changed: []string{}})},
+				hashes: map[string]interface{}{"status.vnets": hash},
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  "Rows of status.vnets changed: 1 added, 1 removed, 0 changed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"DeliveryFailed": {
+			reason: "A failed delivery should emit a Warning and keep the previous hash",
+			args: args{
+				input:    input(""),
+				xr:       xr(`{"vnets":[{"id":"/old"}],"vnetsMetadata":{"contentHashes":{"status.vnets":"` + oldHash + `"}}}`),
+				statuses: []int{http.StatusInternalServerError},
+			},
+			want: want{
+				events: []cloudEvent{event(oldHash, nil)},
+				hashes: map[string]interface{}{"status.vnets": oldHash},
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "cannot notify the change of status.vnets: cannot deliver event after 1 attempts: endpoint responded 500 Internal Server Error",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"HangingEndpoint": {
+			reason: "An endpoint that does not respond should not hold up RunFunction past the notify budget, whatever the timeout and retries",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"notify": {"url": "$url", "timeoutSeconds": 30, "maxRetries": 5}
+				}`,
+				xr:     xr(`{"vnets":[{"id":"/old"}],"vnetsMetadata":{"contentHashes":{"status.vnets":"` + oldHash + `"}}}`),
+				delay:  time.Hour,
+				budget: 200 * time.Millisecond,
+			},
+			want: want{
+				events: []cloudEvent{event(oldHash, nil)},
+				hashes: map[string]interface{}{"status.vnets": oldHash},
+				results: []*fnv1.Result{queried, {
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "cannot notify the change of status.vnets: cannot deliver event: context deadline exceeded",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"TimeoutOutOfBounds": {
+			reason: "A timeoutSeconds above the bound should be fatal",
+			args: args{
+				input: input(`, "timeoutSeconds": 300`),
+				xr:    xr(`{}`),
+			},
+			want: want{
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "notify timeoutSeconds 300 must be between 1 and 30",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"MaxRetriesOutOfBounds": {
+			reason: "A maxRetries above the bound should be fatal",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"notify": {"url": "$url", "maxRetries": 50}
+				}`,
+				xr: xr(`{}`),
+			},
+			want: want{
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "notify maxRetries 50 must be between 0 and 5",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"NotifyURLNotAllowed": {
+			reason: "A notify URL outside the allow-list of the policy should be fatal before anything is delivered",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"notify": {"url": "http://169.254.169.254/metadata/identity"}
+				}`,
+				xr:     xr(`{}`),
+				policy: &Policy{AllowedNotifyURLs: []string{"hooks.example.com"}},
+			},
+			want: want{
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  `notify url "http://169.254.169.254/metadata/identity" rejected by function policy: host 169.254.169.254 is not allowed`,
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"MissingSigningSecret": {
+			reason: "A signing secret that is not passed to the function should be fatal",
+			args: args{
+				input: input(`, "signingSecretRef": {"name": "webhook"}`),
+				xr:    xr(`{}`),
+			},
+			want: want{
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "cannot get webhook credentials for the notify signing secret",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sink := &eventSink{statuses: tc.args.statuses, delay: tc.args.delay}
+			srv := httptest.NewServer(sink)
+			defer srv.Close()
+
+			f := &Function{
+				azureQuery:   mockRows(rows...),
+				notifier:     &HTTPNotifier{client: srv.Client(), backoff: time.Millisecond},
+				notifyBudget: tc.args.budget,
+				policy:       tc.args.policy,
+				log:          logging.NewNopLogger(),
+				now:          func() time.Time { return now },
+			}
+
+			in := resource.MustStructJSON(strings.ReplaceAll(tc.args.input, "$url", srv.URL))
+			credentials := testCredentials()
+			for k, v := range tc.args.creds {
+				credentials[k] = v
+			}
+
+			start := time.Now()
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       in,
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.args.xr)}},
+				Credentials: credentials,
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if elapsed := time.Since(start); tc.args.budget > 0 && elapsed > tc.args.budget+time.Second {
+				t.Errorf("%s\nRunFunction(...): took %s, exceeding the notify budget %s", tc.reason, elapsed, tc.args.budget)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+
+			events := sink.events(t)
+			for i := range events {
+				// The fingerprint has its own tests
+				events[i].Data.Fingerprint = ""
+			}
+			if diff := cmp.Diff(tc.want.events, events); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want events, +got events:\n%s", tc.reason, diff)
+			}
+			if tc.args.creds != nil && len(sink.headers) > 0 && sink.headers[0].Get(SignatureHeader) == "" {
+				t.Errorf("%s\nRunFunction(...): event is not signed", tc.reason)
+			}
+
+			hashes := recordedContentHashes(&v1beta1.Input{Target: "status.vnets", Notify: &v1beta1.Notify{}}, rsp)
+			if diff := cmp.Diff(tc.want.hashes, hashes); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want content hashes, +got content hashes:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
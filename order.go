@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// sortKey is a parsed sortBy entry.
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// canonicalRows de-duplicates and sorts the query rows, so the value written
+// to the targets only changes when the data in Azure does, not when the order
+// or the paging of the rows returned by Azure Resource Graph does.
+func canonicalRows(in *v1beta1.Input, data interface{}) (interface{}, error) {
+	if len(in.DedupeBy) == 0 && len(in.SortBy) == 0 {
+		return data, nil
+	}
+
+	keys, err := parseSortBy(in.SortBy)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := normalizeJSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot copy query result")
+	}
+	rows, err := toRows("sortBy and dedupeBy", normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	rows = dedupeRows(in.DedupeBy, rows)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareRows(keys, rows[i], rows[j]) < 0
+	})
+
+	out := make([]interface{}, len(rows))
+	for i, row := range rows {
+		out[i] = row
+	}
+	return out, nil
+}
+
+func parseSortBy(sortBy []string) ([]sortKey, error) {
+	keys := make([]sortKey, len(sortBy))
+	for i, s := range sortBy {
+		fields := strings.Fields(s)
+		switch {
+		case len(fields) == 1:
+			keys[i] = sortKey{column: fields[0]}
+		case len(fields) == 2 && strings.EqualFold(fields[1], "asc"):
+			keys[i] = sortKey{column: fields[0]}
+		case len(fields) == 2 && strings.EqualFold(fields[1], "desc"):
+			keys[i] = sortKey{column: fields[0], desc: true}
+		default:
+			return nil, errors.Errorf("invalid sortBy %q, expected a column optionally followed by asc or desc", s)
+		}
+	}
+	return keys, nil
+}
+
+// dedupeRows keeps the first of the rows with the same values in the columns.
+// A missing column counts as null.
+func dedupeRows(columns []string, rows []map[string]interface{}) []map[string]interface{} {
+	if len(columns) == 0 {
+		return rows
+	}
+	seen := make(map[string]bool, len(rows))
+	deduped := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i, c := range columns {
+			values[i] = row[c]
+		}
+		// Rows are plain JSON values, marshalling them cannot fail
+		b, _ := json.Marshal(values)
+		if seen[string(b)] {
+			continue
+		}
+		seen[string(b)] = true
+		deduped = append(deduped, row)
+	}
+	return deduped
+}
+
+func compareRows(keys []sortKey, a, b map[string]interface{}) int {
+	for _, k := range keys {
+		c := compareValues(a[k.column], b[k.column])
+		if k.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues orders JSON values. Values of different types are ordered
+// null, booleans, numbers, strings, then arrays and objects.
+func compareValues(a, b interface{}) int {
+	if c := cmp.Compare(typeRank(a), typeRank(b)); c != 0 {
+		return c
+	}
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		y, _ := b.(bool)
+		return cmp.Compare(boolRank(x), boolRank(y))
+	case float64:
+		y, _ := b.(float64)
+		return cmp.Compare(x, y)
+	case string:
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	default:
+		ja, _ := json.Marshal(a)
+		jb, _ := json.Marshal(b)
+		return strings.Compare(string(ja), string(jb))
+	}
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
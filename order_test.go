@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+)
+
+func TestCanonicalRows(t *testing.T) {
+	type args struct {
+		in   *v1beta1.Input
+		data interface{}
+	}
+	type want struct {
+		rows interface{}
+		err  string
+	}
+
+	row := func(kv ...interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return m
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"NoOptions": {
+			reason: "Rows should be passed through untouched without sortBy and dedupeBy",
+			args: args{
+				in:   &v1beta1.Input{},
+				data: []interface{}{row("name", "b"), row("name", "a")},
+			},
+			want: want{rows: []interface{}{row("name", "b"), row("name", "a")}},
+		},
+		"SortBy": {
+			reason: "Rows should be sorted by every key in turn, honoring desc",
+			args: args{
+				in: &v1beta1.Input{SortBy: []string{"location", "name DESC"}},
+				data: []interface{}{
+					row("name", "a", "location", "westeurope"),
+					row("name", "b", "location", "centralus"),
+					row("name", "c", "location", "westeurope"),
+				},
+			},
+			want: want{rows: []interface{}{
+				row("name", "b", "location", "centralus"),
+				row("name", "c", "location", "westeurope"),
+				row("name", "a", "location", "westeurope"),
+			}},
+		},
+		"SortByMixedTypes": {
+			reason: "Missing values should sort first, then booleans, numbers and strings",
+			args: args{
+				in: &v1beta1.Input{SortBy: []string{"v asc"}},
+				data: []interface{}{
+					row("v", "x"),
+					row("v", float64(10)),
+					row("v", float64(2)),
+					row("v", true),
+					row(),
+				},
+			},
+			want: want{rows: []interface{}{
+				row(),
+				row("v", true),
+				row("v", float64(2)),
+				row("v", float64(10)),
+				row("v", "x"),
+			}},
+		},
+		"DedupeBy": {
+			reason: "Rows with the same values in the dedupeBy columns should collapse into the first one",
+			args: args{
+				in: &v1beta1.Input{DedupeBy: []string{"id"}, SortBy: []string{"id"}},
+				data: []interface{}{
+					row("id", "/b", "page", float64(1)),
+					row("id", "/a", "page", float64(1)),
+					row("id", "/b", "page", float64(2)),
+				},
+			},
+			want: want{rows: []interface{}{
+				row("id", "/a", "page", float64(1)),
+				row("id", "/b", "page", float64(1)),
+			}},
+		},
+		"InvalidSortBy": {
+			reason: "A sortBy entry with an unknown direction should be rejected",
+			args: args{
+				in:   &v1beta1.Input{SortBy: []string{"name sideways"}},
+				data: []interface{}{},
+			},
+			want: want{err: `invalid sortBy "name sideways", expected a column optionally followed by asc or desc`},
+		},
+		"NotRows": {
+			reason: "sortBy should require the query result to be rows",
+			args: args{
+				in:   &v1beta1.Input{SortBy: []string{"name"}},
+				data: []interface{}{"a"},
+			},
+			want: want{err: "sortBy and dedupeBy requires rows to be objects, row 0 is string"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := canonicalRows(tc.args.in, tc.args.data)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\ncanonicalRows(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.rows, got); diff != "" {
+				t.Errorf("%s\ncanonicalRows(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
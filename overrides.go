@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// overridable reads and writes an Input setting that may be overridden per XR.
+type overridable struct {
+	// set checks the XR value and writes it to the Input
+	set func(in *v1beta1.Input, v interface{}) error
+	// get returns the effective value for the debug output
+	get func(in *v1beta1.Input) interface{}
+}
+
+// overridables are the Input settings that may be overridden, in the order
+// they are reported.
+var overridables = []struct {
+	setting v1beta1.OverrideSetting
+	overridable
+}{
+	{v1beta1.OverrideQueryInterval, overridable{
+		set: func(in *v1beta1.Input, v interface{}) error {
+			s, ok := v.(string)
+			if !ok {
+				return errors.Errorf("expected a duration, got %T", v)
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return errors.Errorf("expected a non-negative duration, got %q", s)
+			}
+			in.QueryInterval, in.QueryIntervalMinutes = s, nil
+			return nil
+		},
+		get: func(in *v1beta1.Input) interface{} { return in.QueryInterval },
+	}},
+	{v1beta1.OverrideQueryIntervalMinutes, overridable{
+		set: func(in *v1beta1.Input, v interface{}) error {
+			n, err := overrideInt(v, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			in.QueryInterval, in.QueryIntervalMinutes = "", n
+			return nil
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefInt(in.QueryIntervalMinutes) },
+	}},
+	{v1beta1.OverrideJitter, overridable{
+		set: func(in *v1beta1.Input, v interface{}) (err error) {
+			in.Jitter, err = overrideInt(v, 100)
+			return err
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefInt(in.Jitter) },
+	}},
+	{v1beta1.OverrideSkipQueryWhenTargetHasData, overridable{
+		set: func(in *v1beta1.Input, v interface{}) error {
+			b, err := overrideBool(v)
+			if err != nil {
+				return err
+			}
+			in.SkipQueryWhenTargetHasData = b
+			return nil
+		},
+		get: func(in *v1beta1.Input) interface{} { return skipWhenHasData(in) },
+	}},
+	{v1beta1.OverrideSubscriptions, overridable{
+		set: func(in *v1beta1.Input, v interface{}) (err error) {
+			in.Subscriptions, err = overrideStrings(v)
+			return err
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefStrings(in.Subscriptions) },
+	}},
+	{v1beta1.OverrideManagementGroups, overridable{
+		set: func(in *v1beta1.Input, v interface{}) (err error) {
+			in.ManagementGroups, err = overrideStrings(v)
+			return err
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefStrings(in.ManagementGroups) },
+	}},
+	{v1beta1.OverrideMaxRows, overridable{
+		set: func(in *v1beta1.Input, v interface{}) (err error) {
+			in.MaxRows, err = overrideInt(v, math.MaxInt32)
+			return err
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefInt(in.MaxRows) },
+	}},
+	{v1beta1.OverrideMaxBytes, overridable{
+		set: func(in *v1beta1.Input, v interface{}) (err error) {
+			in.MaxBytes, err = overrideInt(v, math.MaxInt32)
+			return err
+		},
+		get: func(in *v1beta1.Input) interface{} { return derefInt(in.MaxBytes) },
+	}},
+	{v1beta1.OverrideOnLimitExceeded, overridable{
+		set: func(in *v1beta1.Input, v interface{}) error {
+			switch a := v1beta1.LimitAction(fmt.Sprint(v)); a {
+			case v1beta1.LimitActionTruncate, v1beta1.LimitActionFail, v1beta1.LimitActionKeepPrevious:
+				in.OnLimitExceeded = a
+				return nil
+			default:
+				return errors.Errorf("expected truncate, fail or keepPrevious, got %v", v)
+			}
+		},
+		get: func(in *v1beta1.Input) interface{} { return string(in.OnLimitExceeded) },
+	}},
+}
+
+// applyOverrides overrides the Input settings listed in its overrides with the
+// values of the observed XR fields. Fields the XR does not have leave the
+// setting as it is, values that are not valid for the setting are rejected.
+func (f *Function) applyOverrides(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	if len(in.Overrides) == 0 {
+		return nil
+	}
+
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		response.Fatal(rsp, errors.Wrap(err, "cannot get observed composite resource"))
+		return err
+	}
+	paved := fieldpath.Pave(oxr.Resource.Object)
+
+	overridden := make([]string, 0, len(in.Overrides))
+	for _, o := range in.Overrides {
+		applied, err := applyOverride(paved, in, o)
+		if err != nil {
+			response.Fatal(rsp, err)
+			return err
+		}
+		if applied {
+			overridden = append(overridden, string(o.Setting))
+		}
+	}
+
+	keysAndValues := []interface{}{"overridden", overridden}
+	for _, s := range overridables {
+		keysAndValues = append(keysAndValues, string(s.setting), s.get(in))
+	}
+	f.log.Debug("Effective query settings", keysAndValues...)
+	return nil
+}
+
+// applyOverride overrides one setting and reports whether the XR has the field.
+func applyOverride(paved *fieldpath.Paved, in *v1beta1.Input, o v1beta1.Override) (bool, error) {
+	var setting *overridable
+	for i := range overridables {
+		if overridables[i].setting == o.Setting {
+			setting = &overridables[i].overridable
+		}
+	}
+	if setting == nil {
+		return false, errors.Errorf("setting %q cannot be overridden", o.Setting)
+	}
+
+	v, err := paved.GetValue(o.FromFieldPath)
+	if fieldpath.IsNotFound(err) || (err == nil && v == nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot read override of %s from %s", o.Setting, o.FromFieldPath)
+	}
+	if err := setting.set(in, v); err != nil {
+		return false, errors.Wrapf(err, "invalid override of %s from %s", o.Setting, o.FromFieldPath)
+	}
+	return true, nil
+}
+
+// overrideInt accepts a whole number, or a string holding one, between 0 and
+// maxValue.
+func overrideInt(v interface{}, maxValue int) (*int, error) {
+	var n float64
+	switch x := v.(type) {
+	case float64:
+		n = x
+	case int64:
+		n = float64(x)
+	case string:
+		i, err := strconv.Atoi(x)
+		if err != nil {
+			return nil, errors.Errorf("expected a whole number, got %q", x)
+		}
+		n = float64(i)
+	default:
+		return nil, errors.Errorf("expected a whole number, got %T", v)
+	}
+	if n != math.Trunc(n) || n < 0 || n > float64(maxValue) {
+		return nil, errors.Errorf("expected a whole number between 0 and %d, got %v", maxValue, n)
+	}
+	return to.Ptr(int(n)), nil
+}
+
+// overrideBool accepts a boolean, or a string holding one.
+func overrideBool(v interface{}) (*bool, error) {
+	switch x := v.(type) {
+	case bool:
+		return to.Ptr(x), nil
+	case string:
+		b, err := strconv.ParseBool(x)
+		if err != nil {
+			return nil, errors.Errorf("expected a boolean, got %q", x)
+		}
+		return to.Ptr(b), nil
+	default:
+		return nil, errors.Errorf("expected a boolean, got %T", v)
+	}
+}
+
+// overrideStrings accepts a list of strings.
+func overrideStrings(v interface{}) ([]*string, error) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected a list of strings, got %T", v)
+	}
+	out := make([]*string, len(arr))
+	for i, e := range arr {
+		s, ok := e.(string)
+		if !ok {
+			return nil, errors.Errorf("expected a list of strings, [%d] is a %T", i, e)
+		}
+		out[i] = to.Ptr(s)
+	}
+	return out, nil
+}
+
+func derefInt(p *int) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func derefStrings(ps []*string) []string {
+	out := make([]string, 0, len(ps))
+	for _, p := range ps {
+		if p != nil {
+			out = append(out, *p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestOverrides(t *testing.T) {
+	creds := azureCredentials(`{
+"clientId": "test-client-id",
+"clientSecret": "test-client-secret",
+"tenantId": "test-tenant-id"
+}`)
+	fatal := func(msg string) *fnv1.Result {
+		return &fnv1.Result{Severity: fnv1.Severity_SEVERITY_FATAL, Message: msg, Target: fnv1.Target_TARGET_COMPOSITE.Enum()}
+	}
+
+	type args struct {
+		input string
+		xr    string
+	}
+	type want struct {
+		results    []*fnv1.Result
+		skipReason string
+		// in holds the overridable settings of the Input passed to the query
+		in *v1beta1.Input
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"FromSpec": {
+			reason: "Settings should be overridden with the values of the XR fields",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"subscriptions": ["00000000-0000-0000-0000-000000000001"],
+					"maxRows": 100,
+					"overrides": [
+						{"setting": "subscriptions", "fromFieldPath": "spec.parameters.subscriptions"},
+						{"setting": "maxRows", "fromFieldPath": "spec.parameters.maxRows"}
+					]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{
+					"subscriptions": ["00000000-0000-0000-0000-000000000002"],
+					"maxRows": 5000
+				}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{queried},
+				in: &v1beta1.Input{
+					Subscriptions: []*string{to.Ptr("00000000-0000-0000-0000-000000000002")},
+					MaxRows:       to.Ptr(5000),
+				},
+			},
+		},
+		"FromAnnotation": {
+			reason: "Booleans and numbers should be read from annotation strings",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"overrides": [{"setting": "skipQueryWhenTargetHasData", "fromFieldPath": "metadata.annotations[example.org/skip-query]"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"example.org/skip-query":"true"}},
+					"status":{"vnets":[{"id":"/a"}]}}`,
+			},
+			want: want{
+				skipReason: "SkippedQuery",
+			},
+		},
+		"FieldMissing": {
+			reason: "A setting should keep the Input value if the XR does not have the field",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 100,
+					"overrides": [{"setting": "maxRows", "fromFieldPath": "spec.parameters.maxRows"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{queried},
+				in:      &v1beta1.Input{MaxRows: to.Ptr(100)},
+			},
+		},
+		"NotDeclared": {
+			reason: "XR fields should be ignored for settings the Input does not list",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"maxRows": 100,
+					"overrides": [{"setting": "maxBytes", "fromFieldPath": "spec.parameters.maxBytes"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{"maxRows":5000}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{queried},
+				in:      &v1beta1.Input{MaxRows: to.Ptr(100)},
+			},
+		},
+		"InvalidValue": {
+			reason: "A value that is not valid for the setting should be rejected",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"overrides": [{"setting": "jitter", "fromFieldPath": "spec.parameters.jitter"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{"jitter":150}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal("invalid override of jitter from spec.parameters.jitter: expected a whole number between 0 and 100, got 150")},
+			},
+		},
+		"InvalidInterval": {
+			reason: "A queryInterval that is not a duration should be rejected",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": 10,
+					"overrides": [{"setting": "queryInterval", "fromFieldPath": "spec.parameters.queryInterval"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{"queryInterval":"soon"}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal(`invalid override of queryInterval from spec.parameters.queryInterval: time: invalid duration "soon"`)},
+			},
+		},
+		"NegativeInterval": {
+			reason: "A negative queryInterval should be rejected",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"overrides": [{"setting": "queryInterval", "fromFieldPath": "spec.parameters.queryInterval"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{"queryInterval":"-1h"}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal(`invalid override of queryInterval from spec.parameters.queryInterval: expected a non-negative duration, got "-1h"`)},
+			},
+		},
+		"UnknownSetting": {
+			reason: "A setting that cannot be overridden should be rejected",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"overrides": [{"setting": "query", "fromFieldPath": "spec.parameters.query"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"parameters":{"query":"Resources"}}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal(`setting "query" cannot be overridden`)},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got *v1beta1.Input
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, in *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						got = &v1beta1.Input{Subscriptions: in.Subscriptions, MaxRows: in.MaxRows}
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{Data: []interface{}{map[string]interface{}{"id": "/a"}}},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.args.input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.args.xr)}},
+				Credentials: creds,
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			reason := ""
+			for _, c := range rsp.GetConditions() {
+				if c.GetType() == "FunctionSkip" {
+					reason = c.GetReason()
+				}
+			}
+			if diff := cmp.Diff(tc.want.skipReason, reason); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want skip reason, +got skip reason:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.in, got); diff != "" {
+				t.Errorf("%s\nazQuery(...): -want settings, +got settings:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+var (
+	// tableRegex matches the names of Azure Resource Graph tables, e.g.
+	// Resources, ResourceContainers, SecurityResources or ResourceChanges.
+	tableRegex = regexp.MustCompile(`(?i)^\w*(Resources|ResourceContainers|ResourceChanges|ResourceContainerChanges)$`)
+
+	// quotedIdentifierRegex matches KQL bracket-quoted identifiers, e.g.
+	// ['SecurityResources'] or ["Resources"]. They may name tables.
+	quotedIdentifierRegex = regexp.MustCompile(`\[\s*@?(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)")\s*\]`)
+
+	// nonWordRegex matches the characters of a quoted identifier that cannot
+	// appear in a plain one.
+	nonWordRegex = regexp.MustCompile(`\W`)
+
+	// stringLiteralRegex matches KQL string literals and comments, which are
+	// removed before looking for table names.
+	stringLiteralRegex = regexp.MustCompile(`@?'(?:[^'\\]|\\.)*'|@?"(?:[^"\\]|\\.)*"|//[^\n]*`)
+
+	// identifierRegex matches KQL identifiers, including a preceding dot so
+	// property accesses such as properties.resources can be skipped.
+	identifierRegex = regexp.MustCompile(`\.?[A-Za-z_][A-Za-z0-9_]*`)
+
+	// sourceKeywords start a query with a source other than a table name.
+	sourceKeywords = map[string]bool{"let": true, "union": true, "print": true, "datatable": true, "range": true}
+)
+
+// Policy constrains the subscriptions, management groups and tables that
+// queries may touch, and the URLs events may be delivered to. Empty lists do
+// not restrict anything.
+type Policy struct {
+	// AllowedSubscriptions that queries may run against.
+	AllowedSubscriptions []string `json:"allowedSubscriptions,omitempty"`
+
+	// AllowedManagementGroups that queries may run against.
+	AllowedManagementGroups []string `json:"allowedManagementGroups,omitempty"`
+
+	// AllowedTables that queries may read, e.g. Resources or ResourceContainers.
+	AllowedTables []string `json:"allowedTables,omitempty"`
+
+	// AllowedNotifyURLs that events may be delivered to. An entry is either a
+	// host, e.g. hooks.example.com, or a URL prefix, e.g.
+	// https://hooks.example.com/crossplane.
+	AllowedNotifyURLs []string `json:"allowedNotifyURLs,omitempty"`
+}
+
+// LoadPolicy reads a policy file, if any, and appends the allow-lists passed
+// as flags.
+func LoadPolicy(path string, flags Policy) (*Policy, error) {
+	p := &Policy{}
+	if path != "" {
+		data, err := os.ReadFile(path) //nolint:gosec // the policy file is configured by the function operator
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read policy file")
+		}
+		if err := yaml.UnmarshalStrict(data, p); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse policy file %s", path)
+		}
+	}
+
+	p.AllowedSubscriptions = append(p.AllowedSubscriptions, flags.AllowedSubscriptions...)
+	p.AllowedManagementGroups = append(p.AllowedManagementGroups, flags.AllowedManagementGroups...)
+	p.AllowedTables = append(p.AllowedTables, flags.AllowedTables...)
+	p.AllowedNotifyURLs = append(p.AllowedNotifyURLs, flags.AllowedNotifyURLs...)
+
+	if p.empty() {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// empty reports whether the policy restricts nothing.
+func (p *Policy) empty() bool {
+	return p == nil || (len(p.AllowedSubscriptions) == 0 && len(p.AllowedManagementGroups) == 0 && len(p.AllowedTables) == 0 && len(p.AllowedNotifyURLs) == 0)
+}
+
+// Check returns an error describing every way the query request violates the
+// policy.
+func (p *Policy) Check(queryRequest armresourcegraph.QueryRequest) error {
+	if p.empty() {
+		return nil
+	}
+
+	violations := append(p.scopeViolations(queryRequest), p.tableViolations(queryRequest)...)
+	if len(violations) > 0 {
+		return errors.Errorf("query rejected by function policy: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// CheckNotifyURL returns an error unless the notify URL matches an entry of
+// AllowedNotifyURLs. Hosts are compared case-insensitively, URL prefixes must
+// match the scheme, the host and whole path segments.
+func (p *Policy) CheckNotifyURL(rawURL string) error {
+	if p == nil || len(p.AllowedNotifyURLs) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return errors.Errorf("notify url %q rejected by function policy: not a valid URL", rawURL)
+	}
+	for _, a := range p.AllowedNotifyURLs {
+		if notifyURLAllowed(u, strings.TrimSpace(a)) {
+			return nil
+		}
+	}
+	return errors.Errorf("notify url %q rejected by function policy: host %s is not allowed", rawURL, u.Hostname())
+}
+
+// notifyURLAllowed reports whether the URL matches an allow-list entry.
+func notifyURLAllowed(u *url.URL, entry string) bool {
+	if !strings.Contains(entry, "://") {
+		return strings.EqualFold(u.Hostname(), entry)
+	}
+
+	prefix, err := url.Parse(entry)
+	if err != nil || !strings.EqualFold(u.Scheme, prefix.Scheme) || !strings.EqualFold(u.Host, prefix.Host) {
+		return false
+	}
+	path := strings.TrimSuffix(prefix.Path, "/")
+	return u.Path == path || strings.HasPrefix(u.Path, path+"/")
+}
+
+// scopeViolations checks the query scope. Once any scope allow-list is set,
+// every part of the scope must be allowed.
+func (p *Policy) scopeViolations(queryRequest armresourcegraph.QueryRequest) []string {
+	if len(p.AllowedSubscriptions) == 0 && len(p.AllowedManagementGroups) == 0 {
+		return nil
+	}
+
+	var violations []string
+	if len(queryRequest.Subscriptions) == 0 && len(queryRequest.ManagementGroups) == 0 {
+		violations = append(violations, "tenant-wide queries are not allowed")
+	}
+	if denied := notAllowed(queryRequest.Subscriptions, p.AllowedSubscriptions); len(denied) > 0 {
+		violations = append(violations, "subscriptions not allowed: "+strings.Join(denied, ", "))
+	}
+	if denied := notAllowed(queryRequest.ManagementGroups, p.AllowedManagementGroups); len(denied) > 0 {
+		violations = append(violations, "management groups not allowed: "+strings.Join(denied, ", "))
+	}
+	return violations
+}
+
+// tableViolations checks the tables read by the query.
+func (p *Policy) tableViolations(queryRequest armresourcegraph.QueryRequest) []string {
+	if len(p.AllowedTables) == 0 || queryRequest.Query == nil {
+		return nil
+	}
+
+	tables := queryTables(*queryRequest.Query)
+	if len(tables) == 0 {
+		return []string{"cannot determine the tables read by the query"}
+	}
+	if denied := notAllowed(toPtrs(tables), p.AllowedTables); len(denied) > 0 {
+		return []string{"tables not allowed: " + strings.Join(denied, ", ")}
+	}
+	return nil
+}
+
+// notAllowed returns the requested values missing from the allow-list,
+// compared case-insensitively.
+func notAllowed(requested []*string, allowList []string) []string {
+	allowed := make(map[string]bool, len(allowList))
+	for _, a := range allowList {
+		allowed[strings.ToLower(strings.TrimSpace(a))] = true
+	}
+
+	var denied []string
+	for _, r := range requested {
+		if r == nil {
+			continue
+		}
+		if !allowed[strings.ToLower(*r)] {
+			denied = append(denied, *r)
+		}
+	}
+	return denied
+}
+
+// queryTables returns the Azure Resource Graph tables referenced by a KQL
+// query. Table names are recognized by their well-known suffixes, so this is a
+// conservative heuristic rather than a full KQL parser.
+func queryTables(query string) []string {
+	stripped := stringLiteralRegex.ReplaceAllString(unquoteIdentifiers(query), " ")
+
+	seen := map[string]bool{}
+	var tables []string
+	if readsImplicitTable(stripped) {
+		seen["resources"] = true
+		tables = append(tables, "Resources")
+	}
+	for _, ident := range identifierRegex.FindAllString(stripped, -1) {
+		if strings.HasPrefix(ident, ".") || !tableRegex.MatchString(ident) {
+			continue
+		}
+		if seen[strings.ToLower(ident)] {
+			continue
+		}
+		seen[strings.ToLower(ident)] = true
+		tables = append(tables, ident)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// readsImplicitTable reports whether the query starts with an operator rather
+// than a source, e.g. "project id, name" or "| where type =~ 'x'", which Azure
+// Resource Graph runs against the Resources table. Sources are table names,
+// parenthesised queries and the sourceKeywords.
+func readsImplicitTable(stripped string) bool {
+	trimmed := strings.TrimSpace(stripped)
+	if trimmed == "" || strings.HasPrefix(trimmed, "(") {
+		return false
+	}
+	first := identifierRegex.FindString(trimmed)
+	if first == "" || !strings.HasPrefix(trimmed, first) {
+		return true
+	}
+	return !tableRegex.MatchString(first) && !sourceKeywords[strings.ToLower(first)]
+}
+
+// unquoteIdentifiers replaces bracket-quoted identifiers with plain ones, so
+// ['SecurityResources'] is found as a table rather than skipped as a string
+// literal. Characters that cannot appear in a plain identifier become
+// underscores, which keeps the name a single identifier. A bracket right
+// after an identifier, ) or ] indexes a value, e.g. properties['resources'],
+// and is dropped like a property access.
+func unquoteIdentifiers(query string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range quotedIdentifierRegex.FindAllStringSubmatchIndex(query, -1) {
+		b.WriteString(query[last:m[0]])
+		last = m[1]
+		if m[0] > 0 && indexes(query[m[0]-1]) {
+			b.WriteString(" ")
+			continue
+		}
+		name := ""
+		switch {
+		case m[2] >= 0:
+			name = query[m[2]:m[3]]
+		case m[4] >= 0:
+			name = query[m[4]:m[5]]
+		}
+		b.WriteString(" " + nonWordRegex.ReplaceAllString(name, "_") + " ")
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// indexes reports whether a bracket right after c indexes a value.
+func indexes(c byte) bool {
+	return c == ']' || c == ')' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/logging"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	type args struct {
+		policy  *Policy
+		request armresourcegraph.QueryRequest
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   string
+	}{
+		"NilPolicy": {
+			reason: "A nil policy should allow everything",
+			args: args{
+				request: armresourcegraph.QueryRequest{Query: to.Ptr("Resources | count")},
+			},
+		},
+		"AllowedSubscription": {
+			reason: "Subscriptions in the allow-list should be accepted case-insensitively",
+			args: args{
+				policy: &Policy{AllowedSubscriptions: []string{"00000000-0000-0000-0000-00000000000A"}},
+				request: armresourcegraph.QueryRequest{
+					Query:         to.Ptr("Resources | count"),
+					Subscriptions: []*string{to.Ptr("00000000-0000-0000-0000-00000000000a")},
+				},
+			},
+		},
+		"DeniedSubscription": {
+			reason: "Subscriptions missing from the allow-list should be rejected",
+			args: args{
+				policy: &Policy{AllowedSubscriptions: []string{sub1}},
+				request: armresourcegraph.QueryRequest{
+					Query:         to.Ptr("Resources | count"),
+					Subscriptions: []*string{to.Ptr(sub1), to.Ptr(sub2)},
+				},
+			},
+			want: "query rejected by function policy: subscriptions not allowed: " + sub2,
+		},
+		"TenantScope": {
+			reason: "Tenant-wide queries should be rejected once a scope allow-list is set",
+			args: args{
+				policy:  &Policy{AllowedManagementGroups: []string{"mg1"}},
+				request: armresourcegraph.QueryRequest{Query: to.Ptr("Resources | count")},
+			},
+			want: "query rejected by function policy: tenant-wide queries are not allowed",
+		},
+		"DeniedManagementGroup": {
+			reason: "Management groups should be rejected when only subscriptions are allowed",
+			args: args{
+				policy: &Policy{AllowedSubscriptions: []string{sub1}},
+				request: armresourcegraph.QueryRequest{
+					Query:            to.Ptr("Resources | count"),
+					ManagementGroups: []*string{to.Ptr("mg1")},
+				},
+			},
+			want: "query rejected by function policy: management groups not allowed: mg1",
+		},
+		"AllowedTables": {
+			reason: "Queries reading only allowed tables should be accepted",
+			args: args{
+				policy: &Policy{AllowedTables: []string{"resources", "ResourceContainers"}},
+				request: armresourcegraph.QueryRequest{
+					Query: to.Ptr("Resources | join kind=leftouter (ResourceContainers | project subscriptionId) on subscriptionId"),
+				},
+			},
+		},
+		"DeniedTable": {
+			reason: "Queries reading a table missing from the allow-list should be rejected",
+			args: args{
+				policy: &Policy{AllowedTables: []string{"Resources"}},
+				request: armresourcegraph.QueryRequest{
+					Query: to.Ptr("SecurityResources | where type == 'microsoft.security/assessments'"),
+				},
+			},
+			want: "query rejected by function policy: tables not allowed: SecurityResources",
+		},
+		"QuotedTable": {
+			reason: "Tables named by bracket-quoted identifiers should not bypass the allow-list",
+			args: args{
+				policy: &Policy{AllowedTables: []string{"Resources"}},
+				request: armresourcegraph.QueryRequest{
+					Query: to.Ptr("Resources | union (['securityresources'])"),
+				},
+			},
+			want: "query rejected by function policy: tables not allowed: securityresources",
+		},
+		"DeniedImplicitTable": {
+			reason: "Queries without a leading table should be checked as reading the Resources table",
+			args: args{
+				policy:  &Policy{AllowedTables: []string{"SecurityResources"}},
+				request: armresourcegraph.QueryRequest{Query: to.Ptr("project id, name | union (SecurityResources | project id, name)")},
+			},
+			want: "query rejected by function policy: tables not allowed: Resources",
+		},
+		"UnknownTables": {
+			reason: "Queries whose tables cannot be determined should be rejected when tables are restricted",
+			args: args{
+				policy:  &Policy{AllowedTables: []string{"Resources"}},
+				request: armresourcegraph.QueryRequest{Query: to.Ptr("print 'Resources'")},
+			},
+			want: "query rejected by function policy: cannot determine the tables read by the query",
+		},
+		"AllViolations": {
+			reason: "Every violation should be reported",
+			args: args{
+				policy: &Policy{AllowedSubscriptions: []string{sub1}, AllowedTables: []string{"Resources"}},
+				request: armresourcegraph.QueryRequest{
+					Query:         to.Ptr("ResourceContainers | count"),
+					Subscriptions: []*string{to.Ptr(sub2)},
+				},
+			},
+			want: "query rejected by function policy: subscriptions not allowed: " + sub2 + "; tables not allowed: ResourceContainers",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := ""
+			if err := tc.args.policy.Check(tc.args.request); err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nCheck(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestQueryTables(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		query  string
+		want   []string
+	}{
+		"Simple": {
+			reason: "A single table should be found",
+			query:  "Resources | project name, location",
+			want:   []string{"Resources"},
+		},
+		"Join": {
+			reason: "Joined tables should be found and de-duplicated",
+			query:  "resources | join (ResourceContainers | where type == 'x') on subscriptionId | union Resources",
+			want:   []string{"ResourceContainers", "resources"},
+		},
+		"IgnoresPropertiesAndLiterals": {
+			reason: "Property accesses, string literals and comments should not be mistaken for tables",
+			query:  "AdvisorResources // from SecurityResources\n| extend r = properties.resources | where name == \"PolicyResources\"",
+			want:   []string{"AdvisorResources"},
+		},
+		"ImplicitTable": {
+			reason: "A query starting with an operator should read the Resources table",
+			query:  "project id, name | union (SecurityResources | project id, name)",
+			want:   []string{"Resources", "SecurityResources"},
+		},
+		"PipeFirst": {
+			reason: "A query starting with a pipe should read the Resources table",
+			query:  "| where type =~ 'microsoft.network/virtualnetworks' | join (SecurityResources) on id",
+			want:   []string{"Resources", "SecurityResources"},
+		},
+		"ImplicitTableOnce": {
+			reason: "The implicit Resources table should not be listed twice",
+			query:  "where type =~ 'x' | join (resources) on id",
+			want:   []string{"Resources"},
+		},
+		"ExplicitSources": {
+			reason: "Let statements, union and parenthesised queries should not read the Resources table",
+			query:  "let vnets = SecurityResources | project id; union vnets, (AdvisorResources)",
+			want:   []string{"AdvisorResources", "SecurityResources"},
+		},
+		"QuotedIdentifiers": {
+			reason: "Bracket-quoted identifiers should be found as tables",
+			query:  "Resources | union (['securityresources']), [\"Policy Resources\"] | extend r = properties['advisorresources']",
+			want:   []string{"Policy_Resources", "Resources", "securityresources"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := queryTables(tc.query)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nqueryTables(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(valid, []byte("allowedSubscriptions:\n- "+sub1+"\nallowedTables:\n- Resources\nallowedNotifyURLs:\n- hooks.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unknown := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknown, []byte("allowedTable:\n- Resources\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		policy *Policy
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		path   string
+		flags  Policy
+		want   want
+	}{
+		"Empty": {
+			reason: "No file and no flags should result in no policy",
+		},
+		"FlagsOnly": {
+			reason: "Flags alone should form the policy",
+			flags:  Policy{AllowedTables: []string{"Resources"}},
+			want:   want{policy: &Policy{AllowedTables: []string{"Resources"}}},
+		},
+		"FileAndFlags": {
+			reason: "Flags should be appended to the allow-lists from the file",
+			path:   valid,
+			flags:  Policy{AllowedSubscriptions: []string{sub2}, AllowedNotifyURLs: []string{"https://events.example.com/xr"}},
+			want: want{policy: &Policy{
+				AllowedSubscriptions: []string{sub1, sub2},
+				AllowedTables:        []string{"Resources"},
+				AllowedNotifyURLs:    []string{"hooks.example.com", "https://events.example.com/xr"},
+			}},
+		},
+		"UnknownField": {
+			reason: "Misspelled fields should be rejected rather than silently allowing everything",
+			path:   unknown,
+			want:   want{err: true},
+		},
+		"MissingFile": {
+			reason: "A missing policy file should be an error",
+			path:   filepath.Join(dir, "missing.yaml"),
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := LoadPolicy(tc.path, tc.flags)
+			if (err != nil) != tc.want.err {
+				t.Fatalf("%s\nLoadPolicy(...): want err %t, got %v", tc.reason, tc.want.err, err)
+			}
+			if diff := cmp.Diff(tc.want.policy, got); diff != "" {
+				t.Errorf("%s\nLoadPolicy(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCheckNotifyURL(t *testing.T) {
+	allowList := []string{"Hooks.Example.com", "https://events.example.com/crossplane/"}
+
+	cases := map[string]struct {
+		reason    string
+		allowList []string
+		url       string
+		want      error
+	}{
+		"NoAllowList": {
+			reason: "Without an allow-list every URL should be allowed",
+			url:    "http://169.254.169.254/metadata",
+		},
+		"AllowedHost": {
+			reason:    "Hosts should be compared case-insensitively, on any scheme, port and path",
+			allowList: allowList,
+			url:       "http://hooks.example.COM:8080/anything",
+		},
+		"AllowedPrefix": {
+			reason:    "A URL below an allowed prefix should be allowed",
+			allowList: allowList,
+			url:       "https://events.example.com/crossplane/xr?team=a",
+		},
+		"PrefixPathSegment": {
+			reason:    "A prefix should only match whole path segments",
+			allowList: allowList,
+			url:       "https://events.example.com/crossplane-evil",
+			want:      cmpopts.AnyError,
+		},
+		"PrefixScheme": {
+			reason:    "A prefix should only match its own scheme",
+			allowList: allowList,
+			url:       "http://events.example.com/crossplane/xr",
+			want:      cmpopts.AnyError,
+		},
+		"Subdomain": {
+			reason:    "A host entry should not allow its subdomains or lookalike hosts",
+			allowList: allowList,
+			url:       "https://hooks.example.com.attacker.net/",
+			want:      cmpopts.AnyError,
+		},
+		"UserInfo": {
+			reason:    "Userinfo should not be mistaken for the host",
+			allowList: allowList,
+			url:       "https://hooks.example.com@169.254.169.254/",
+			want:      cmpopts.AnyError,
+		},
+		"InvalidURL": {
+			reason:    "A URL that cannot be parsed should be rejected",
+			allowList: allowList,
+			url:       "http://[::1",
+			want:      cmpopts.AnyError,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := &Policy{AllowedNotifyURLs: tc.allowList}
+			err := p.CheckNotifyURL(tc.url)
+			if diff := cmp.Diff(tc.want, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nCheckNotifyURL(%q): -want error, +got error:\n%s", tc.reason, tc.url, diff)
+			}
+		})
+	}
+}
+
+func TestAzQueryPolicy(t *testing.T) {
+	a := &AzureQuery{policy: &Policy{AllowedSubscriptions: []string{sub1}}}
+	creds := map[string]string{
+		SubscriptionID: sub2,
+		TenantID:       "tenant",
+		ClientID:       "client",
+		ClientSecret:   "secret",
+	}
+	in := &v1beta1.Input{Query: "Resources | count"}
+
+	_, err := a.azQuery(context.Background(), creds, in, logging.NewNopLogger())
+	want := "query rejected by function policy: subscriptions not allowed: " + sub2
+	if err == nil || err.Error() != want {
+		t.Errorf("azQuery(...): policy should reject the query before authenticating, want %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// Reasons of the success condition of a failed query.
+const (
+	reasonQueryFailed   = "QueryFailed"
+	reasonThrottled     = "Throttled"
+	reasonUnauthorized  = "Unauthorized"
+	reasonForbidden     = "Forbidden"
+	reasonInvalidQuery  = "InvalidQuery"
+	reasonScopeNotFound = "ScopeNotFound"
+	reasonTimeout       = "Timeout"
+	reasonTransient     = "Transient"
+	reasonRejected      = "QueryRejected"
+)
+
+// rejectedError is a query the function policy or the scope policy rejected
+// before it ran. It is a configuration error, so onError never downgrades it.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+// rejected marks err as a rejection of the query.
+func rejected(err error) error {
+	return &rejectedError{err: err}
+}
+
+// statusReasons classifies Azure Resource Graph responses by status code.
+var statusReasons = map[int]string{
+	http.StatusBadRequest:         reasonInvalidQuery,
+	http.StatusUnauthorized:       reasonUnauthorized,
+	http.StatusForbidden:          reasonForbidden,
+	http.StatusNotFound:           reasonScopeNotFound,
+	http.StatusRequestTimeout:     reasonTimeout,
+	http.StatusTooManyRequests:    reasonThrottled,
+	http.StatusGatewayTimeout:     reasonTimeout,
+	http.StatusServiceUnavailable: reasonTransient,
+}
+
+// scopeNotFoundCodes are the error codes of queries whose subscriptions or
+// management groups do not exist or cannot be seen. Azure Resource Graph
+// reports some of them as bad requests.
+var scopeNotFoundCodes = []string{
+	"SubscriptionNotFound",
+	"InvalidSubscriptionId",
+	"NoValidSubscriptionsInQueryRequest",
+	"ManagementGroupNotFound",
+}
+
+// reasonMessages prefix the message of a classified error.
+var reasonMessages = map[string]string{
+	reasonThrottled:     "query throttled by Azure Resource Graph",
+	reasonUnauthorized:  "cannot authenticate to Azure",
+	reasonForbidden:     "not allowed to query Azure Resource Graph",
+	reasonInvalidQuery:  "invalid query",
+	reasonScopeNotFound: "subscriptions or management groups not found",
+	reasonTimeout:       "query timed out",
+	reasonTransient:     "Azure Resource Graph is temporarily unavailable",
+}
+
+// argError is the error body returned by Azure Resource Graph.
+type argError struct {
+	Error struct {
+		Code    string           `json:"code"`
+		Message string           `json:"message"`
+		Details []argErrorDetail `json:"details"`
+	} `json:"error"`
+}
+
+// argErrorDetail is a detail of an Azure Resource Graph error. Syntax errors
+// carry where in the query they are.
+type argErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line"`
+	// CharacterPositionInLine counts from 0
+	CharacterPositionInLine *int   `json:"characterPositionInLine"`
+	Token                   string `json:"token"`
+}
+
+// handleQueryError reports a failed query as onError asks. The condition
+// reason classifies the error. With warn and ignore the targets keep their
+// data and the error is recorded in the query metadata, so the pipeline goes
+// on. Queries rejected by the function or scope policy are always fatal.
+func (f *Function) handleQueryError(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse, queryErr error) {
+	if d, ok := retryAfter(queryErr, f.clock()); ok {
+		f.setTTL(rsp, d)
+	}
+	reason, err := classifyQueryError(queryErr)
+
+	action := in.OnError
+	if reason == reasonRejected {
+		action = v1beta1.ErrorActionFatal
+	}
+	switch action {
+	case v1beta1.ErrorActionWarn:
+		response.Warning(rsp, errors.Wrap(err, "query failed, keeping the previous data"))
+	case v1beta1.ErrorActionIgnore:
+		f.log.Info("Query failed, keeping the previous data", "reason", reason, "error", err)
+	default:
+		response.Fatal(rsp, err)
+		// The fatal result says it all for errors that are not classified
+		if reason != reasonQueryFailed {
+			setQueryFailed(rsp, in, reason, err)
+		}
+		return
+	}
+	setQueryFailed(rsp, in, reason, err)
+
+	// The context does not survive between reconciles, restore what the last
+	// successful query cached
+	if err := f.restoreContextTargets(req, in, rsp); err != nil {
+		f.log.Debug("Cannot restore context targets after a failed query", "error", err)
+	}
+	if err := f.recordQueryError(req, rsp, in, err); err != nil {
+		f.log.Debug("Cannot record the query error in the query metadata", "error", err)
+	}
+}
+
+// setQueryFailed sets the success condition of a failed query.
+func setQueryFailed(rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, reason string, err error) {
+	response.ConditionFalse(rsp, successCondition(in), reason).
+		WithMessage(err.Error()).
+		TargetCompositeAndClaim()
+}
+
+// classifyQueryError returns the condition reason of a failed query and the
+// error to report. Azure errors are reported by their code and message
+// rather than the full response, invalid queries with where the error is.
+// Errors that cannot be classified are returned as they are.
+func classifyQueryError(err error) (string, error) {
+	var authErr *azidentity.AuthenticationFailedError
+	var re *azcore.ResponseError
+	var netErr net.Error
+	var rejErr *rejectedError
+	switch {
+	case errors.As(err, &rejErr):
+		return reasonRejected, err
+	case errors.As(err, &authErr):
+		return reasonUnauthorized, errors.Wrap(err, reasonMessages[reasonUnauthorized])
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout, errors.Wrap(err, reasonMessages[reasonTimeout])
+	case errors.As(err, &re):
+		return classifyResponseError(re)
+	case errors.As(err, &netErr):
+		return reasonTransient, errors.Wrap(err, reasonMessages[reasonTransient])
+	default:
+		return reasonQueryFailed, err
+	}
+}
+
+// classifyResponseError classifies an error response of Azure Resource Graph
+// by its error codes, then by its status code.
+func classifyResponseError(re *azcore.ResponseError) (string, error) {
+	body := parseARGError(re)
+	codes := []string{re.ErrorCode, body.Error.Code}
+	for _, d := range body.Error.Details {
+		codes = append(codes, d.Code)
+	}
+
+	reason, ok := statusReasons[re.StatusCode]
+	switch {
+	case hasAnyCode(codes, scopeNotFoundCodes...):
+		reason = reasonScopeNotFound
+	case hasAnyCode(codes, "RateLimiting"):
+		reason = reasonThrottled
+	case !ok && re.StatusCode >= http.StatusInternalServerError:
+		reason = reasonTransient
+	case !ok:
+		return reasonQueryFailed, re
+	}
+
+	detail := describeARGError(re, body)
+	if reason == reasonInvalidQuery {
+		if positions := syntaxErrors(body.Error.Details); positions != "" {
+			detail = positions
+		}
+	}
+	return reason, errors.Errorf("%s: %s", reasonMessages[reason], detail)
+}
+
+// parseARGError parses the error body of the response, if it has one.
+func parseARGError(re *azcore.ResponseError) argError {
+	body := argError{}
+	if re.RawResponse == nil {
+		return body
+	}
+	if payload, err := runtime.Payload(re.RawResponse); err == nil {
+		_ = json.Unmarshal(payload, &body)
+	}
+	return body
+}
+
+// describeARGError returns the code and message of the error, without the
+// request and response dump of the ResponseError.
+func describeARGError(re *azcore.ResponseError, body argError) string {
+	code := body.Error.Code
+	if code == "" {
+		code = re.ErrorCode
+	}
+	if code == "" {
+		code = fmt.Sprintf("%d %s", re.StatusCode, http.StatusText(re.StatusCode))
+	}
+	if body.Error.Message == "" {
+		return code
+	}
+	return code + ": " + body.Error.Message
+}
+
+// syntaxErrors describes the details of an invalid query that say where in
+// the query the error is, e.g. line 1, column 12: ParserFailure near "|".
+func syntaxErrors(details []argErrorDetail) string {
+	var out []string
+	for _, d := range details {
+		if d.Line == nil {
+			continue
+		}
+		s := fmt.Sprintf("line %d", *d.Line)
+		if d.CharacterPositionInLine != nil {
+			s += fmt.Sprintf(", column %d", *d.CharacterPositionInLine+1)
+		}
+		s += ": " + d.Message
+		if d.Token != "" {
+			s += fmt.Sprintf(" near %q", d.Token)
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, "; ")
+}
+
+// hasAnyCode reports whether any of the codes is one of want.
+func hasAnyCode(codes []string, want ...string) bool {
+	for _, c := range codes {
+		for _, w := range want {
+			if c != "" && strings.EqualFold(c, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordQueryError adds the error and its time to the query metadata until
+// the next successful query.
+func (f *Function) recordQueryError(req *fnv1.RunFunctionRequest, rsp *fnv1.RunFunctionResponse, in *v1beta1.Input, queryErr error) error {
+	return f.updateQueryMetadata(req, in, withRefresh(req, map[string]interface{}{
+		"lastError":     queryErr.Error(),
+		"lastErrorTime": f.clock().Format(time.RFC3339),
+	}), rsp)
+}
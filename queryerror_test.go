@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestOnError(t *testing.T) {
+	creds := azureCredentials(`{
+"clientId": "test-client-id",
+"clientSecret": "test-client-secret",
+"subscriptionId": "` + sub1 + `",
+"tenantId": "test-tenant-id"
+}`)
+	now := time.Now().Truncate(time.Second)
+	earlier := now.Add(-time.Hour).Format(time.RFC3339)
+	input := func(target, onError string) string {
+		return `{
+			"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+			"kind": "Input",
+			"query": "Resources| count",
+			"target": "` + target + `",
+			"queryIntervalMinutes": 10,
+			"onError": "` + onError + `"
+		}`
+	}
+	failed := &fnv1.Condition{
+		Type:    "FunctionSuccess",
+		Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+		Reason:  "QueryFailed",
+		Message: to.Ptr("query failed"),
+		Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+	}
+
+	type args struct {
+		input string
+		xr    string
+		// err is returned by the query, query failed if nil
+		err error
+		// policy, if set, runs the query through AzureQuery, which rejects
+		// it before authenticating
+		policy *Policy
+	}
+	type want struct {
+		status     string
+		context    string
+		results    []*fnv1.Result
+		conditions []*fnv1.Condition
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Fatal": {
+			reason: "A failed query should be fatal by default",
+			args: args{
+				input: input("status.vnets", "fatal"),
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + earlier + `"}}}`,
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + earlier + `"}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets","vnetsMetadata"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "query failed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+			},
+		},
+		"FatalClassified": {
+			reason: "A failed query should set the FunctionSuccess condition with the reason of the Azure error",
+			args: args{
+				input: input("status.vnets", "fatal"),
+				xr:    `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+				err: runtime.NewResponseError(&http.Response{
+					StatusCode: http.StatusForbidden,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"AuthorizationFailed","message":"No access."}}`)),
+				}),
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "not allowed to query Azure Resource Graph: AuthorizationFailed: No access.",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{{
+					Type:    "FunctionSuccess",
+					Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+					Reason:  "Forbidden",
+					Message: to.Ptr("not allowed to query Azure Resource Graph: AuthorizationFailed: No access."),
+					Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+				}},
+			},
+		},
+		"Warn": {
+			reason: "A failed query should emit a Warning, keep the data and record the error",
+			args: args{
+				input: input("status.vnets", "warn"),
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + earlier + `"}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + earlier + `",
+					"lastError": "query failed",
+					"lastErrorTime": "` + now.Format(time.RFC3339) + `"
+				}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "query failed, keeping the previous data: query failed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{failed},
+			},
+		},
+		"WarnRecordsRefresh": {
+			reason: "A failed query kept by onError should record the refresh it handled",
+			args: args{
+				input: input("status.vnets", "warn"),
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","annotations":{"` + RefreshAnnotation + `":"1"}},
+					"status":{"vnets":[{"id":"/old"}],"vnetsMetadata":{"lastQueryTime":"` + now.Add(-time.Minute).Format(time.RFC3339) + `"}}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-time.Minute).Format(time.RFC3339) + `",
+					"lastError": "query failed",
+					"lastErrorTime": "` + now.Format(time.RFC3339) + `",
+					"refreshRequestedAt": "1"
+				}}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_WARNING,
+					Message:  "query failed, keeping the previous data: query failed",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{failed},
+			},
+		},
+		"Ignore": {
+			reason: "A failed query should keep the data and record the error without a result",
+			args: args{
+				input: input("status.vnets", "ignore"),
+				xr:    `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+			},
+			want: want{
+				status: `{"vnets":[{"id":"/old"}],"vnetsMetadata":{
+					"lastError": "query failed",
+					"lastErrorTime": "` + now.Format(time.RFC3339) + `"
+				}}`,
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				conditions: []*fnv1.Condition{failed},
+			},
+		},
+		"IgnoreRejected": {
+			reason: "A query rejected by the function policy should be fatal whatever onError says",
+			args: args{
+				input:  input("status.vnets", "ignore"),
+				xr:     `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+				policy: &Policy{AllowedTables: []string{"ResourceContainers"}},
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "query rejected by function policy: tables not allowed: Resources",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{{
+					Type:    "FunctionSuccess",
+					Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+					Reason:  "QueryRejected",
+					Message: to.Ptr("query rejected by function policy: tables not allowed: Resources"),
+					Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+				}},
+			},
+		},
+		"WarnRejectedScope": {
+			reason: "A query rejected by the scope policy should be fatal whatever onError says",
+			args: args{
+				input: input("status.vnets", "warn"),
+				xr:    `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnets":[{"id":"/old"}]}}`,
+				err:   rejected(errors.New("none of the input subscriptions are allowed by the credentials")),
+			},
+			want: want{
+				status:  `{"vnets":[{"id":"/old"}]}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnets"]}}`,
+				results: []*fnv1.Result{{
+					Severity: fnv1.Severity_SEVERITY_FATAL,
+					Message:  "none of the input subscriptions are allowed by the credentials",
+					Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+				}},
+				conditions: []*fnv1.Condition{{
+					Type:    "FunctionSuccess",
+					Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+					Reason:  "QueryRejected",
+					Message: to.Ptr("none of the input subscriptions are allowed by the credentials"),
+					Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+				}},
+			},
+		},
+		"IgnoreRestoresContext": {
+			reason: "A failed query should restore the cached context targets",
+			args: args{
+				input: input("context.vnets", "ignore"),
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},
+					"status":{"vnetsMetadata":{"lastQueryTime":"` + earlier + `","cache":{"context.vnets":[{"id":"/old"}]}}}}`,
+			},
+			want: want{
+				status: `{"vnetsMetadata":{
+					"lastQueryTime": "` + earlier + `",
+					"cache": {"context.vnets":[{"id":"/old"}]},
+					"lastError": "query failed",
+					"lastErrorTime": "` + now.Format(time.RFC3339) + `"
+				}}`,
+				context:    `{"vnets":[{"id":"/old"}]}`,
+				conditions: []*fnv1.Condition{failed},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						if tc.args.err != nil {
+							return armresourcegraph.ClientResourcesResponse{}, tc.args.err
+						}
+						return armresourcegraph.ClientResourcesResponse{}, errors.New("query failed")
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+			if tc.args.policy != nil {
+				f.azureQuery = &AzureQuery{policy: tc.args.policy}
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.args.input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.args.xr)}},
+				Context:     resource.MustStructJSON(`{}`),
+				Credentials: creds,
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.conditions, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want conditions, +got conditions:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.status), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestClassifyQueryError(t *testing.T) {
+	respErr := func(status int, body string) error {
+		return errors.Wrap(runtime.NewResponseError(&http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}), "failed to finish the request")
+	}
+
+	type want struct {
+		reason string
+		err    string
+	}
+
+	cases := map[string]struct {
+		reason string
+		err    error
+		want   want
+	}{
+		"Throttled": {
+			reason: "A 429 response should be classified as Throttled",
+			err:    respErr(http.StatusTooManyRequests, `{"error":{"code":"RateLimiting","message":"Please provide below info when asking for support"}}`),
+			want: want{
+				reason: "Throttled",
+				err:    "query throttled by Azure Resource Graph: RateLimiting: Please provide below info when asking for support",
+			},
+		},
+		"Unauthorized": {
+			reason: "A 401 response should be classified as Unauthorized",
+			err:    respErr(http.StatusUnauthorized, `{"error":{"code":"InvalidAuthenticationToken","message":"The access token is invalid."}}`),
+			want: want{
+				reason: "Unauthorized",
+				err:    "cannot authenticate to Azure: InvalidAuthenticationToken: The access token is invalid.",
+			},
+		},
+		"Forbidden": {
+			reason: "A 403 response should be classified as Forbidden",
+			err:    respErr(http.StatusForbidden, `{"error":{"code":"AuthorizationFailed","message":"The client does not have authorization."}}`),
+			want: want{
+				reason: "Forbidden",
+				err:    "not allowed to query Azure Resource Graph: AuthorizationFailed: The client does not have authorization.",
+			},
+		},
+		"InvalidQuery": {
+			reason: "A bad request should be classified as InvalidQuery with the position of the syntax error",
+			err: respErr(http.StatusBadRequest, `{"error":{"code":"BadRequest","message":"Please provide below info when asking for support","details":[
+				{"code":"InvalidQuery","message":"Query is invalid. Please refer to the documentation for the Azure Resource Graph service and fix the error before retrying."},
+				{"code":"ParserFailure","message":"ParserFailure","line":2,"characterPositionInLine":10,"token":"|"}
+			]}}`),
+			want: want{
+				reason: "InvalidQuery",
+				err:    `invalid query: line 2, column 11: ParserFailure near "|"`,
+			},
+		},
+		"InvalidQueryWithoutPosition": {
+			reason: "A bad request without a position should be reported by its code and message",
+			err:    respErr(http.StatusBadRequest, `{"error":{"code":"BadRequest","message":"Query is invalid."}}`),
+			want: want{
+				reason: "InvalidQuery",
+				err:    "invalid query: BadRequest: Query is invalid.",
+			},
+		},
+		"ScopeNotFound": {
+			reason: "A bad request for unknown subscriptions should be classified as ScopeNotFound",
+			err: respErr(http.StatusBadRequest, `{"error":{"code":"BadRequest","message":"No valid subscriptions","details":[
+				{"code":"NoValidSubscriptionsInQueryRequest","message":"Query request does not have any valid subscriptions."}
+			]}}`),
+			want: want{
+				reason: "ScopeNotFound",
+				err:    "subscriptions or management groups not found: BadRequest: No valid subscriptions",
+			},
+		},
+		"GatewayTimeout": {
+			reason: "A 504 response should be classified as Timeout",
+			err:    respErr(http.StatusGatewayTimeout, ``),
+			want: want{
+				reason: "Timeout",
+				err:    "query timed out: 504 Gateway Timeout",
+			},
+		},
+		"DeadlineExceeded": {
+			reason: "An exceeded deadline should be classified as Timeout",
+			err:    errors.Wrap(context.DeadlineExceeded, "failed to finish the request"),
+			want: want{
+				reason: "Timeout",
+				err:    "query timed out: failed to finish the request: context deadline exceeded",
+			},
+		},
+		"Transient": {
+			reason: "A server error should be classified as Transient",
+			err:    respErr(http.StatusInternalServerError, `{"error":{"code":"InternalServerError","message":"Encountered an internal server error."}}`),
+			want: want{
+				reason: "Transient",
+				err:    "Azure Resource Graph is temporarily unavailable: InternalServerError: Encountered an internal server error.",
+			},
+		},
+		"ConnectionRefused": {
+			reason: "A network error should be classified as Transient",
+			err:    errors.Wrap(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, "failed to finish the request"),
+			want: want{
+				reason: "Transient",
+				err:    "Azure Resource Graph is temporarily unavailable: failed to finish the request: dial tcp: connection refused",
+			},
+		},
+		"AuthenticationFailed": {
+			reason: "A failure to get a token should be classified as Unauthorized",
+			err:    errors.Wrap(&azidentity.AuthenticationFailedError{}, "failed to finish the request"),
+			want: want{
+				reason: "Unauthorized",
+				err:    "cannot authenticate to Azure: failed to finish the request: " + (&azidentity.AuthenticationFailedError{}).Error(),
+			},
+		},
+		"Rejected": {
+			reason: "A query rejected by a policy should be classified as QueryRejected and reported as it is",
+			err:    rejected(errors.New("query rejected by function policy: tables not allowed: SecurityResources")),
+			want: want{
+				reason: "QueryRejected",
+				err:    "query rejected by function policy: tables not allowed: SecurityResources",
+			},
+		},
+		"Unclassified": {
+			reason: "Other errors should be returned as they are",
+			err:    errors.New("invalid credential format"),
+			want: want{
+				reason: "QueryFailed",
+				err:    "invalid credential format",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			reason, err := classifyQueryError(tc.err)
+			if diff := cmp.Diff(tc.want, want{reason: reason, err: err.Error()}, cmp.AllowUnexported(want{})); diff != "" {
+				t.Errorf("%s\nclassifyQueryError(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
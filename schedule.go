@@ -0,0 +1,289 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// queryInterval returns the minimum interval between queries, 0 if there is
+// none.
+func queryInterval(in *v1beta1.Input) (time.Duration, error) {
+	if in.QueryInterval != "" {
+		if in.QueryIntervalMinutes != nil {
+			return 0, errors.New("set either queryInterval or queryIntervalMinutes, not both")
+		}
+		d, err := time.ParseDuration(in.QueryInterval)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid queryInterval %q", in.QueryInterval)
+		}
+		if d < 0 {
+			return 0, errors.Errorf("queryInterval %q must not be negative", in.QueryInterval)
+		}
+		return d, nil
+	}
+	if in.QueryIntervalMinutes != nil {
+		if *in.QueryIntervalMinutes < 0 {
+			return 0, errors.Errorf("queryIntervalMinutes %d must not be negative", *in.QueryIntervalMinutes)
+		}
+		return time.Duration(*in.QueryIntervalMinutes) * time.Minute, nil
+	}
+	return 0, nil
+}
+
+// withJitter lengthens the interval by a share of the jitter percentage that
+// is derived from the seed, so the same XR always gets the same interval.
+func withJitter(interval time.Duration, jitter *int, seed string) time.Duration {
+	if jitter == nil || *jitter <= 0 || seed == "" {
+		return interval
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(seed))
+	share := float64(h.Sum64()) / math.MaxUint64
+	return interval + time.Duration(float64(interval)*float64(*jitter)/100*share)
+}
+
+// scheduled reports whether queries may be skipped because of the time of the
+// last query or the schedule. The query metadata then has to survive between
+// reconciles.
+func scheduled(in *v1beta1.Input) bool {
+	return intervalSet(in) || len(in.Schedule) > 0
+}
+
+// validateSchedule checks the query interval, the jitter and the schedule
+// windows.
+func validateSchedule(in *v1beta1.Input) error {
+	if _, err := queryInterval(in); err != nil {
+		return err
+	}
+	if in.Jitter != nil && (*in.Jitter < 0 || *in.Jitter > 100) {
+		return errors.Errorf("jitter %d must be a percentage between 0 and 100", *in.Jitter)
+	}
+	for i, w := range in.Schedule {
+		if _, err := parseWindow(w); err != nil {
+			return errors.Wrapf(err, "invalid schedule window %d", i)
+		}
+	}
+	return nil
+}
+
+// inSchedule reports whether t is in any of the schedule windows. Windows that
+// cannot be parsed never match, validateSchedule reports them.
+func inSchedule(windows []v1beta1.ScheduleWindow, t time.Time) bool {
+	return matchesAny(scheduleMatchers(windows), t)
+}
+
+// nextInSchedule returns the first minute from t on that is in any of the
+// schedule windows, or t plus the horizon if there is none before.
+func nextInSchedule(windows []v1beta1.ScheduleWindow, t time.Time, horizon time.Duration) time.Time {
+	matchers := scheduleMatchers(windows)
+	if matchesAny(matchers, t) {
+		return t
+	}
+	end := t.Add(horizon)
+	for next := t.Truncate(time.Minute).Add(time.Minute); next.Before(end); next = next.Add(time.Minute) {
+		if matchesAny(matchers, next) {
+			return next
+		}
+	}
+	return end
+}
+
+func scheduleMatchers(windows []v1beta1.ScheduleWindow) []func(time.Time) bool {
+	matchers := make([]func(time.Time) bool, 0, len(windows))
+	for _, w := range windows {
+		if m, err := parseWindow(w); err == nil {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers
+}
+
+func matchesAny(matchers []func(time.Time) bool, t time.Time) bool {
+	for _, m := range matchers {
+		if m(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindow returns a function that reports whether a time is in the window.
+func parseWindow(w v1beta1.ScheduleWindow) (func(time.Time) bool, error) {
+	loc := time.UTC
+	if w.TimeZone != "" {
+		l, err := time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid timeZone %q", w.TimeZone)
+		}
+		loc = l
+	}
+
+	var match func(time.Time) bool
+	var err error
+	switch {
+	case w.Cron != "" && w.Hours != "":
+		return nil, errors.New("set either cron or hours, not both")
+	case w.Cron != "":
+		var c *cronSchedule
+		c, err = parseCron(w.Cron)
+		if c != nil {
+			match = c.matches
+		}
+	case w.Hours != "":
+		match, err = parseHours(w.Hours)
+	default:
+		return nil, errors.New("either cron or hours is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(t time.Time) bool { return match(t.In(loc)) }, nil
+}
+
+// parseHours parses an hour range like 9-17. The range wraps around midnight
+// if the end hour is before the start hour.
+func parseHours(s string) (func(time.Time) bool, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, errors.Errorf("invalid hours %q, expected a range like 9-17", s)
+	}
+	f, okFrom := parseHour(from, 23)
+	t, okTo := parseHour(to, 24)
+	if !okFrom || !okTo || f == t {
+		return nil, errors.Errorf("invalid hours %q, expected a range like 9-17", s)
+	}
+	return func(tm time.Time) bool {
+		h := tm.Hour()
+		if f < t {
+			return h >= f && h < t
+		}
+		return h >= f || h < t
+	}, nil
+}
+
+func parseHour(s string, maxHour int) (int, bool) {
+	h, err := strconv.Atoi(strings.TrimSpace(s))
+	return h, err == nil && h >= 0 && h <= maxHour
+}
+
+// cronSchedule is a parsed five-field cron expression. Each field is a bit set
+// of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny record a * in the day fields. As in cron, a day
+	// matches either day field if both are restricted.
+	domAny, dowAny bool
+}
+
+// cronFields are the bounds of the fields of a cron expression.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("invalid cron %q, expected 5 fields", expr)
+	}
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron %q, %s", expr, cronFields[i].name)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a comma separated list of *, values and ranges, each
+// optionally followed by a /step.
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, stepped := part, 1, false
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, errors.Errorf("invalid step %q", s)
+			}
+			rng, step, stepped = r, n, true
+		}
+
+		from, to, err := parseCronRange(rng, stepped, lo, hi)
+		if err != nil {
+			return 0, err
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// parseCronRange parses *, a value or a range of a cron field. A single value
+// with a step, e.g. 5/15, runs to the end of the field.
+func parseCronRange(rng string, stepped bool, lo, hi int) (int, int, error) {
+	if rng == "*" {
+		return lo, hi, nil
+	}
+
+	f, t, isRange := strings.Cut(rng, "-")
+	from, err := strconv.Atoi(f)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid value %q", f)
+	}
+	to := from
+	switch {
+	case isRange:
+		if to, err = strconv.Atoi(t); err != nil {
+			return 0, 0, errors.Errorf("invalid value %q", t)
+		}
+	case stepped:
+		to = hi
+	}
+	if from < lo || to > hi || from > to {
+		return 0, 0, errors.Errorf("%q is out of range %d-%d", rng, lo, hi)
+	}
+	return from, to, nil
+}
+
+// matches reports whether the minute of t matches the expression.
+func (c *cronSchedule) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
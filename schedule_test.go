@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestInSchedule(t *testing.T) {
+	// A Wednesday
+	noon := time.Date(2024, 1, 3, 12, 30, 0, 0, time.UTC)
+
+	type args struct {
+		windows []v1beta1.ScheduleWindow
+		t       time.Time
+	}
+	type want struct {
+		in  bool
+		err string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Hours": {
+			reason: "A time within the hour range should be in the schedule",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Hours: "9-17"}},
+				t:       noon,
+			},
+			want: want{in: true},
+		},
+		"HoursEndExcluded": {
+			reason: "The end hour of the range should be excluded",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Hours: "9-12"}},
+				t:       noon,
+			},
+			want: want{in: false},
+		},
+		"HoursAroundMidnight": {
+			reason: "A range ending before it starts should wrap around midnight",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Hours: "22-6"}},
+				t:       noon.Add(-9 * time.Hour),
+			},
+			want: want{in: true},
+		},
+		"TimeZone": {
+			reason: "The window should be evaluated in its time zone",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Hours: "13-14", TimeZone: "Europe/Berlin"}},
+				t:       noon,
+			},
+			want: want{in: true},
+		},
+		"Cron": {
+			reason: "A time matching the cron expression should be in the schedule",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Cron: "*/15 12 * * 1-5"}},
+				t:       noon,
+			},
+			want: want{in: true},
+		},
+		"CronDayOfWeek": {
+			reason: "A time on a day of week the cron expression excludes should not be in the schedule",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Cron: "* * * * 0,6"}},
+				t:       noon,
+			},
+			want: want{in: false},
+		},
+		"CronEitherDay": {
+			reason: "With both day fields restricted a time should match either of them, as in cron",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Cron: "* * 1 * 3"}},
+				t:       noon,
+			},
+			want: want{in: true},
+		},
+		"AnyWindow": {
+			reason: "A time in any of the windows should be in the schedule",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Hours: "0-1"}, {Cron: "30 12 * * *"}},
+				t:       noon,
+			},
+			want: want{in: true},
+		},
+		"InvalidCron": {
+			reason: "A cron value out of range should be rejected",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Cron: "60 * * * *"}},
+				t:       noon,
+			},
+			want: want{err: `invalid schedule window 0: invalid cron "60 * * * *", minute: "60" is out of range 0-59`},
+		},
+		"CronAndHours": {
+			reason: "A window should not set both cron and hours",
+			args: args{
+				windows: []v1beta1.ScheduleWindow{{Cron: "* * * * *", Hours: "9-17"}},
+				t:       noon,
+			},
+			want: want{err: "invalid schedule window 0: set either cron or hours, not both"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			gotErr := ""
+			if err := validateSchedule(&v1beta1.Input{Schedule: tc.args.windows}); err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\nvalidateSchedule(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.in, inSchedule(tc.args.windows, tc.args.t)); diff != "" {
+				t.Errorf("%s\ninSchedule(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	interval := 10 * time.Minute
+
+	a := withJitter(interval, to.Ptr(20), "uid-a")
+	if a < interval || a > interval+2*time.Minute {
+		t.Errorf("withJitter(...): %s is not within 20%% above %s", a, interval)
+	}
+	if diff := cmp.Diff(a, withJitter(interval, to.Ptr(20), "uid-a")); diff != "" {
+		t.Errorf("withJitter(...): the same seed should give the same interval, -first +second:\n%s", diff)
+	}
+	if a == withJitter(interval, to.Ptr(20), "uid-b") {
+		t.Errorf("withJitter(...): different seeds should spread the interval, both got %s", a)
+	}
+	if diff := cmp.Diff(interval, withJitter(interval, nil, "uid-a")); diff != "" {
+		t.Errorf("withJitter(...): no jitter should keep the interval, -want +got:\n%s", diff)
+	}
+}
+
+func TestScheduledQueries(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 1, 3, 12, 30, 0, 0, time.UTC)
+	fatal := func(msg string) *fnv1.Result {
+		return &fnv1.Result{Severity: fnv1.Severity_SEVERITY_FATAL, Message: msg, Target: fnv1.Target_TARGET_COMPOSITE.Enum()}
+	}
+	lastQueried := func(ago time.Duration) string {
+		return `{"lastQueryTime":"` + now.Add(-ago).Format(time.RFC3339) + `"}`
+	}
+
+	type args struct {
+		input string
+		xr    string
+	}
+	type want struct {
+		results    []*fnv1.Result
+		skipReason string
+		skipMsg    string
+		context    string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"OutsideSchedule": {
+			reason: "Outside of the schedule windows the query should be skipped and the context targets restored",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.vnets",
+					"schedule": [{"hours": "1-5"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetsMetadata":{
+					"lastQueryTime": "` + now.Add(-24*time.Hour).Format(time.RFC3339) + `",
+					"cache": {"context.vnets": [{"id":"/cached"}]}
+				}}}`,
+			},
+			want: want{
+				skipReason: "OutsideSchedule",
+				skipMsg:    "Query skipped outside of the schedule windows",
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]},"vnets":[{"id":"/cached"}]}`,
+			},
+		},
+		"OutsideScheduleNeverQueried": {
+			reason: "Outside of the schedule windows the query should still run if it never ran",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"schedule": [{"cron": "* 1-5 * * *"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{queried},
+				context: `{}`,
+			},
+		},
+		"InSchedule": {
+			reason: "Within a schedule window the query should run",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"schedule": [{"hours": "12-13"}]
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetsMetadata":` + lastQueried(time.Hour) + `}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{queried},
+				context: `{}`,
+			},
+		},
+		"QueryInterval": {
+			reason: "queryInterval should accept a duration",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryInterval": "90s"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetsMetadata":` + lastQueried(time.Minute) + `}}`,
+			},
+			want: want{
+				skipReason: "IntervalLimit",
+				skipMsg:    "Query skipped due to interval limit (1m30s)",
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]}}`,
+			},
+		},
+		"QueryIntervalWithJitter": {
+			reason: "The jitter seeded from the XR UID should lengthen the interval",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryInterval": "10m",
+					"jitter": 50
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","uid":"2b5c0a4e-7d1f-4c1b-9a53-6f4c3f3e0d11"},"status":{"vnetsMetadata":` + lastQueried(10*time.Minute) + `}}`,
+			},
+			want: want{
+				skipReason: "IntervalLimit",
+				skipMsg:    "Query skipped due to interval limit (" + withJitter(10*time.Minute, to.Ptr(50), "2b5c0a4e-7d1f-4c1b-9a53-6f4c3f3e0d11").Round(time.Second).String() + ")",
+				context:    `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetsMetadata"]}}`,
+			},
+		},
+		"BothIntervals": {
+			reason: "queryInterval and queryIntervalMinutes should not both be set",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryInterval": "10m",
+					"queryIntervalMinutes": 10
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal("set either queryInterval or queryIntervalMinutes, not both")},
+				context: `{}`,
+			},
+		},
+		"InvalidQueryInterval": {
+			reason: "queryInterval should be a Go duration",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryInterval": "10"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal(`invalid queryInterval "10": time: missing unit in duration "10"`)},
+				context: `{}`,
+			},
+		},
+		"NegativeQueryInterval": {
+			reason: "A negative queryInterval should be rejected rather than querying on every reconcile",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryInterval": "-5m"
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal(`queryInterval "-5m" must not be negative`)},
+				context: `{}`,
+			},
+		},
+		"NegativeQueryIntervalMinutes": {
+			reason: "A negative queryIntervalMinutes should be rejected",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"queryIntervalMinutes": -1
+				}`,
+				xr: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+			},
+			want: want{
+				results: []*fnv1.Result{fatal("queryIntervalMinutes -1 must not be negative")},
+				context: `{}`,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(map[string]interface{}{"id": "/a"}),
+				log:        logging.NewNopLogger(),
+				now:        func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), reconcile(tc.args.input, tc.args.xr))
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			var reason, msg string
+			for _, c := range rsp.GetConditions() {
+				if c.GetType() == "FunctionSkip" {
+					reason, msg = c.GetReason(), c.GetMessage()
+				}
+			}
+			if diff := cmp.Diff(tc.want.skipReason, reason); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want skip reason, +got skip reason:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.skipMsg, msg); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want skip message, +got skip message:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
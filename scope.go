@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// subscriptionIDRegex matches an Azure subscription ID, which is always a GUID
+var subscriptionIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// subscriptionsFromValue converts a value read from a subscriptionsRef into
+// subscriptions. Entries that are not strings are reported rather than dropped.
+func subscriptionsFromValue(ref string, value interface{}) ([]*string, error) {
+	arr, ok := value.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	subscriptions := make([]*string, 0, len(arr))
+	var invalid []string
+	for i, sub := range arr {
+		strSub, ok := sub.(string)
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf("[%d] is a %T", i, sub))
+			continue
+		}
+		subscriptions = append(subscriptions, to.Ptr(strSub))
+	}
+	if len(invalid) > 0 {
+		return nil, errors.Errorf("invalid subscriptions in subscriptionsRef %s: %s, expected strings", ref, strings.Join(invalid, ", "))
+	}
+	return subscriptions, nil
+}
+
+// normalizeSubscriptions de-duplicates subscriptions case-insensitively,
+// keeping the first occurrence, and returns a description of every entry that
+// is not a valid subscription ID.
+func normalizeSubscriptions(source string, subscriptions []*string) ([]string, []string) {
+	seen := make(map[string]bool, len(subscriptions))
+	valid := make([]string, 0, len(subscriptions))
+	var invalid []string
+	for i, sub := range subscriptions {
+		if sub == nil {
+			invalid = append(invalid, fmt.Sprintf("%s[%d] is empty", source, i))
+			continue
+		}
+		id := strings.TrimSpace(*sub)
+		if !subscriptionIDRegex.MatchString(id) {
+			invalid = append(invalid, fmt.Sprintf("%s[%d] %q is not a GUID", source, i, *sub))
+			continue
+		}
+		if seen[strings.ToLower(id)] {
+			continue
+		}
+		seen[strings.ToLower(id)] = true
+		valid = append(valid, id)
+	}
+	return valid, invalid
+}
+
+// resolveScope merges the Input subscriptions with the subscriptions carried
+// by the credentials according to the scope policy. An empty result means the
+// query runs against the whole tenant.
+func resolveScope(policy v1beta1.ScopePolicy, inputSubscriptions []*string, credentialSubscriptions []string, log logging.Logger) ([]string, error) {
+	if policy == "" {
+		policy = v1beta1.ScopePolicyOverride
+	}
+
+	credentialPtrs := make([]*string, len(credentialSubscriptions))
+	for i := range credentialSubscriptions {
+		credentialPtrs[i] = &credentialSubscriptions[i]
+	}
+
+	fromInput, invalidInput := normalizeSubscriptions("subscriptions", inputSubscriptions)
+	fromCreds, invalidCreds := normalizeSubscriptions("credentials", credentialPtrs)
+
+	if invalid := usedInvalid(policy, len(inputSubscriptions) > 0, invalidInput, invalidCreds); len(invalid) > 0 {
+		return nil, errors.Errorf("invalid subscriptions: %s", strings.Join(invalid, "; "))
+	}
+
+	switch policy {
+	case v1beta1.ScopePolicyOverride:
+		return overrideSubscriptions(fromInput, fromCreds, log), nil
+	case v1beta1.ScopePolicyUnion:
+		merged, _ := normalizeSubscriptions("", toPtrs(append(fromInput, fromCreds...)))
+		log.Debug("Using union of input and credential subscriptions", "subscriptionCount", len(merged))
+		return merged, nil
+	case v1beta1.ScopePolicyIntersect:
+		if len(fromCreds) == 0 {
+			// Nothing is allowed, so the query must not widen to the tenant
+			return nil, errors.New("scopePolicy intersect requires the credentials to carry subscriptions")
+		}
+		if len(fromInput) == 0 {
+			log.Debug("No input subscriptions to intersect, using the credential subscriptions", "subscriptionCount", len(fromCreds))
+			return fromCreds, nil
+		}
+		return intersectSubscriptions(fromInput, fromCreds, log)
+	default:
+		return nil, errors.Errorf("unsupported scopePolicy: %s", string(policy))
+	}
+}
+
+// overrideSubscriptions handles subscriptions in the following priority:
+// 1. Use Subscriptions field from Input if provided (from YAML composition)
+// 2. Otherwise use subscriptionIDs from credentials if available (subscriptionId is optional)
+// 3. If no subscriptions specified anywhere, the query will run against the tenant (all accessible subscriptions)
+func overrideSubscriptions(fromInput, fromCreds []string, log logging.Logger) []string {
+	if len(fromInput) > 0 {
+		log.Debug("Using subscriptions from input", "subscriptionCount", len(fromInput))
+		return fromInput
+	}
+	if len(fromCreds) > 0 {
+		log.Debug("Using subscriptions from credentials", "subscriptionCount", len(fromCreds))
+	}
+	return fromCreds
+}
+
+// usedInvalid returns the invalid entries of the subscription lists the policy
+// actually uses, so that an unused list never fails the query.
+func usedInvalid(policy v1beta1.ScopePolicy, hasInput bool, invalidInput, invalidCreds []string) []string {
+	switch {
+	case policy == v1beta1.ScopePolicyOverride && hasInput:
+		return invalidInput
+	case policy == v1beta1.ScopePolicyOverride:
+		return invalidCreds
+	default:
+		return append(invalidInput, invalidCreds...)
+	}
+}
+
+// intersectSubscriptions keeps the input subscriptions that the credentials
+// also carry. An empty intersection is an error rather than a tenant-wide query.
+func intersectSubscriptions(fromInput, fromCreds []string, log logging.Logger) ([]string, error) {
+	allowed := make(map[string]bool, len(fromCreds))
+	for _, sub := range fromCreds {
+		allowed[strings.ToLower(sub)] = true
+	}
+	intersection := []string{}
+	for _, sub := range fromInput {
+		if allowed[strings.ToLower(sub)] {
+			intersection = append(intersection, sub)
+		} else {
+			log.Info("Dropping subscription not allowed by credentials", "subscription", sub)
+		}
+	}
+	if len(intersection) == 0 {
+		return nil, errors.New("none of the input subscriptions are allowed by the credentials")
+	}
+	return intersection, nil
+}
+
+// credentialSubscriptions returns the subscription IDs carried by the credentials.
+func credentialSubscriptions(azureCreds interface{}) []string {
+	subscriptionIDs := []string{}
+	switch v := azureCreds.(type) {
+	case map[string]string:
+		if subID, exists := v[SubscriptionID]; exists && subID != "" {
+			subscriptionIDs = append(subscriptionIDs, subID)
+		}
+	case []map[string]string:
+		for _, cred := range v {
+			if subID, exists := cred[SubscriptionID]; exists && subID != "" {
+				subscriptionIDs = append(subscriptionIDs, subID)
+			}
+		}
+	}
+	return subscriptionIDs
+}
+
+// credentialClientIDs returns the client IDs of the service principals carried
+// by the credentials.
+func credentialClientIDs(azureCreds interface{}) []string {
+	clientIDs := []string{}
+	switch v := azureCreds.(type) {
+	case map[string]string:
+		if clientID := v[ClientID]; clientID != "" {
+			clientIDs = append(clientIDs, clientID)
+		}
+	case []map[string]string:
+		for _, cred := range v {
+			if clientID := cred[ClientID]; clientID != "" {
+				clientIDs = append(clientIDs, clientID)
+			}
+		}
+	}
+	return clientIDs
+}
+
+// tenantScopeAllowed reports whether the Input may query the whole tenant.
+func (f *Function) tenantScopeAllowed(in *v1beta1.Input) bool {
+	if in.AllowTenantScope != nil {
+		return *in.AllowTenantScope
+	}
+	return !f.requireExplicitScope
+}
+
+// checkQueryScope rejects queries that would silently run against the whole
+// tenant because no subscriptions or management groups were resolved.
+func (f *Function) checkQueryScope(in *v1beta1.Input, azureCreds interface{}, rsp *fnv1.RunFunctionResponse) error {
+	if len(in.Subscriptions) > 0 || len(in.ManagementGroups) > 0 || len(credentialSubscriptions(azureCreds)) > 0 {
+		return nil
+	}
+	if f.tenantScopeAllowed(in) {
+		f.log.Debug("Query scope is empty, running against the whole tenant")
+		return nil
+	}
+
+	msg := "query scope is empty: no subscriptions in the Input or credentials and no management groups"
+	if in.SubscriptionsRef != nil {
+		msg = fmt.Sprintf("%s (subscriptionsRef %s resolved to no subscriptions)", msg, *in.SubscriptionsRef)
+	}
+	err := errors.Errorf("%s; set allowTenantScope: true to query the whole tenant", msg)
+	response.ConditionFalse(rsp, successCondition(in), "EmptyScope").
+		WithMessage(err.Error()).
+		TargetCompositeAndClaim()
+	response.Fatal(rsp, err)
+	return err
+}
+
+// toPtrs converts a string slice to a slice of string pointers for the API.
+func toPtrs(in []string) []*string {
+	out := make([]*string, len(in))
+	for i, s := range in {
+		out[i] = to.Ptr(s)
+	}
+	return out
+}
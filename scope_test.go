@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+const (
+	sub1 = "00000000-0000-0000-0000-000000000001"
+	sub2 = "00000000-0000-0000-0000-000000000002"
+	sub3 = "00000000-0000-0000-0000-000000000003"
+)
+
+func TestResolveScope(t *testing.T) {
+	type args struct {
+		policy      v1beta1.ScopePolicy
+		input       []*string
+		credentials []string
+	}
+	type want struct {
+		subscriptions []string
+		err           string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"OverrideDefaultsToInput": {
+			reason: "The default override policy should prefer Input subscriptions",
+			args: args{
+				input:       []*string{to.Ptr(sub1)},
+				credentials: []string{sub2},
+			},
+			want: want{subscriptions: []string{sub1}},
+		},
+		"OverrideFallsBackToCredentials": {
+			reason: "The override policy should use credential subscriptions when the Input has none",
+			args: args{
+				policy:      v1beta1.ScopePolicyOverride,
+				credentials: []string{sub2, sub2},
+			},
+			want: want{subscriptions: []string{sub2}},
+		},
+		"OverrideIgnoresUnusedInvalidCredentials": {
+			reason: "The override policy should not validate credential subscriptions it does not use",
+			args: args{
+				policy:      v1beta1.ScopePolicyOverride,
+				input:       []*string{to.Ptr(sub1)},
+				credentials: []string{"not-a-guid"},
+			},
+			want: want{subscriptions: []string{sub1}},
+		},
+		"TenantScope": {
+			reason: "No subscriptions anywhere should resolve to an empty scope",
+			args:   args{policy: v1beta1.ScopePolicyUnion},
+			want:   want{subscriptions: []string{}},
+		},
+		"Union": {
+			reason: "The union policy should merge and de-duplicate both lists case-insensitively",
+			args: args{
+				policy:      v1beta1.ScopePolicyUnion,
+				input:       []*string{to.Ptr(sub1), to.Ptr(sub2)},
+				credentials: []string{"00000000-0000-0000-0000-00000000000A", sub2, "00000000-0000-0000-0000-00000000000a"},
+			},
+			want: want{subscriptions: []string{sub1, sub2, "00000000-0000-0000-0000-00000000000A"}},
+		},
+		"Intersect": {
+			reason: "The intersect policy should restrict Input subscriptions to those in the credentials",
+			args: args{
+				policy:      v1beta1.ScopePolicyIntersect,
+				input:       []*string{to.Ptr(sub1), to.Ptr(sub2)},
+				credentials: []string{sub2, sub3},
+			},
+			want: want{subscriptions: []string{sub2}},
+		},
+		"IntersectWithoutCredentialSubscriptions": {
+			reason: "The intersect policy should be an error when the credentials carry no subscriptions",
+			args: args{
+				policy: v1beta1.ScopePolicyIntersect,
+				input:  []*string{to.Ptr(sub1)},
+			},
+			want: want{err: "scopePolicy intersect requires the credentials to carry subscriptions"},
+		},
+		"IntersectWithoutSubscriptions": {
+			reason: "The intersect policy should be an error rather than a tenant-wide query when neither list has subscriptions",
+			args: args{
+				policy: v1beta1.ScopePolicyIntersect,
+			},
+			want: want{err: "scopePolicy intersect requires the credentials to carry subscriptions"},
+		},
+		"IntersectWithoutInputSubscriptions": {
+			reason: "The intersect policy should use the credential subscriptions when the Input has none",
+			args: args{
+				policy:      v1beta1.ScopePolicyIntersect,
+				credentials: []string{sub2},
+			},
+			want: want{subscriptions: []string{sub2}},
+		},
+		"EmptyIntersection": {
+			reason: "An empty intersection should be an error instead of a tenant-wide query",
+			args: args{
+				policy:      v1beta1.ScopePolicyIntersect,
+				input:       []*string{to.Ptr(sub1)},
+				credentials: []string{sub2},
+			},
+			want: want{err: "none of the input subscriptions are allowed by the credentials"},
+		},
+		"InvalidSubscriptions": {
+			reason: "Invalid and empty subscriptions should be reported with their index",
+			args: args{
+				policy:      v1beta1.ScopePolicyUnion,
+				input:       []*string{to.Ptr(sub1), nil, to.Ptr("sub1")},
+				credentials: []string{"bad"},
+			},
+			want: want{err: `invalid subscriptions: subscriptions[1] is empty; subscriptions[2] "sub1" is not a GUID; credentials[0] "bad" is not a GUID`},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := resolveScope(tc.args.policy, tc.args.input, tc.args.credentials, logging.NewNopLogger())
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\nresolveScope(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.subscriptions, got); diff != "" {
+				t.Errorf("%s\nresolveScope(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsFromValue(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		value  interface{}
+		want   []*string
+		err    string
+	}{
+		"Strings": {
+			reason: "String entries should be converted to subscriptions",
+			value:  []interface{}{sub1, sub2},
+			want:   []*string{to.Ptr(sub1), to.Ptr(sub2)},
+		},
+		"NotAList": {
+			reason: "A value that is not a list should be ignored",
+			value:  "sub1",
+		},
+		"NonStringEntries": {
+			reason: "Entries that are not strings should be reported instead of becoming nil",
+			value:  []interface{}{sub1, float64(2), map[string]interface{}{}},
+			err:    "invalid subscriptions in subscriptionsRef status.subs: [1] is a float64, [2] is a map[string]interface {}, expected strings",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := subscriptionsFromValue("status.subs", tc.value)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, gotErr); diff != "" {
+				t.Errorf("%s\nsubscriptionsFromValue(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nsubscriptionsFromValue(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestTenantScopeGuard(t *testing.T) {
+	var (
+		xr    = `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"subscriptions":[]}}`
+		creds = azureCredentials(`{
+"clientId": "test-client-id",
+"clientSecret": "test-client-secret",
+"tenantId": "test-tenant-id"
+}`)
+		emptyScopeMessage = "query scope is empty: no subscriptions in the Input or credentials and no management groups (subscriptionsRef status.subscriptions resolved to no subscriptions); set allowTenantScope: true to query the whole tenant"
+	)
+
+	type want struct {
+		conditions []*fnv1.Condition
+		results    []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason               string
+		input                string
+		requireExplicitScope bool
+		want                 want
+	}{
+		"TenantScopeAllowedByDefault": {
+			reason: "Tenant-wide queries should be allowed unless the function requires an explicit scope",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"subscriptionsRef": "status.subscriptions",
+				"target": "context.result"
+			}`,
+			want: want{
+				conditions: []*fnv1.Condition{
+					{
+						Type:   "FunctionSuccess",
+						Status: fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason: "Success",
+						Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+					},
+				},
+				results: []*fnv1.Result{
+					queried,
+				},
+			},
+		},
+		"EmptyScopeRejected": {
+			reason: "An empty scope should be an error when the function requires an explicit scope",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"subscriptionsRef": "status.subscriptions",
+				"target": "context.result"
+			}`,
+			requireExplicitScope: true,
+			want: want{
+				conditions: []*fnv1.Condition{
+					{
+						Type:    "FunctionSuccess",
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  "EmptyScope",
+						Message: to.Ptr(emptyScopeMessage),
+						Target:  fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+					},
+				},
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  emptyScopeMessage,
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"InputAllowsTenantScope": {
+			reason: "The Input should be able to opt in to tenant-wide queries",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"allowTenantScope": true,
+				"target": "context.result"
+			}`,
+			requireExplicitScope: true,
+			want: want{
+				conditions: []*fnv1.Condition{
+					{
+						Type:   "FunctionSuccess",
+						Status: fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason: "Success",
+						Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+					},
+				},
+				results: []*fnv1.Result{
+					queried,
+				},
+			},
+		},
+		"ManagementGroupsAreAScope": {
+			reason: "Management groups should count as an explicit scope",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"managementGroups": ["mg1"],
+				"target": "context.result"
+			}`,
+			requireExplicitScope: true,
+			want: want{
+				conditions: []*fnv1.Condition{
+					{
+						Type:   "FunctionSuccess",
+						Status: fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason: "Success",
+						Target: fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum(),
+					},
+				},
+				results: []*fnv1.Result{
+					queried,
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery:           mockRows(),
+				requireExplicitScope: tc.requireExplicitScope,
+				log:                  logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(xr)}},
+				Credentials: creds,
+			})
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+
+			if diff := cmp.Diff(tc.want.conditions, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want conditions, +got conditions:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// inputTargets returns every target the query result is written to. The
+// Input-level Target, if set, comes first and uses the Input-level shape.
+func inputTargets(in *v1beta1.Input) []v1beta1.Target {
+	targets := make([]v1beta1.Target, 0, len(in.Targets)+1)
+	if in.Target != "" {
+		targets = append(targets, v1beta1.Target{
+			Path:          in.Target,
+			Transform:     in.Transform,
+			OutputFormat:  in.OutputFormat,
+			Column:        in.Column,
+			KeyBy:         in.KeyBy,
+			MergeStrategy: in.MergeStrategy,
+			MergeKey:      in.MergeKey,
+		})
+	}
+	return append(targets, in.Targets...)
+}
+
+// validateInput applies the per-XR overrides to the Input, then checks its
+// schedule, targets, diff and notifications.
+func (f *Function) validateInput(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	if err := f.applyOverrides(req, in, rsp); err != nil {
+		return err
+	}
+	if err := validateSchedule(in); err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+	if err := validateConditionName(in); err != nil {
+		response.Fatal(rsp, err)
+		return err
+	}
+	if in.ConditionName == "" {
+		f.log.Info("conditionName is not set, the conditions are shared with other steps of this function", "successCondition", successCondition(in))
+	}
+	if err := f.validateTargets(in, rsp); err != nil {
+		return err
+	}
+	if err := f.validateDiff(in, rsp); err != nil {
+		return err
+	}
+	return f.validateNotify(req, in, rsp)
+}
+
+// validateTargets checks that there is at least one target and that every
+// target path, including the one of the query metadata, is a status or context
+// field written only once.
+func (f *Function) validateTargets(in *v1beta1.Input, rsp *fnv1.RunFunctionResponse) error {
+	targets := inputTargets(in)
+	if len(targets) == 0 {
+		err := errors.New("either target or targets is required")
+		response.Fatal(rsp, err)
+		return err
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if !f.isValidTarget(t.Path) {
+			err := errors.Errorf("Unrecognized target field: %s", t.Path)
+			response.Fatal(rsp, err)
+			return err
+		}
+		if seen[t.Path] {
+			err := errors.Errorf("target %s is written more than once", t.Path)
+			response.Fatal(rsp, err)
+			return err
+		}
+		seen[t.Path] = true
+	}
+
+	path := metadataPath(in)
+	if !f.isValidTarget(path) || seen[path] {
+		err := errors.Errorf("metadataTarget %s must be a status or context field other than the targets", path)
+		response.Fatal(rsp, err)
+		return err
+	}
+	if scheduled(in) && !strings.HasPrefix(path, "status.") {
+		err := errors.Errorf("metadataTarget %s must be a status field when a query interval or schedule is set", path)
+		response.Fatal(rsp, err)
+		return err
+	}
+	return nil
+}
+
+// firstStatusTarget returns the path of the first status target, if any.
+func firstStatusTarget(in *v1beta1.Input) (string, bool) {
+	for _, t := range inputTargets(in) {
+		if strings.HasPrefix(t.Path, "status.") {
+			return t.Path, true
+		}
+	}
+	return "", false
+}
+
+// shapeResult builds the value written to a target from the query result:
+// the rows are projected to the selected columns, then transformed and
+// finally formatted.
+func shapeResult(t v1beta1.Target, data interface{}) (interface{}, []string, error) {
+	// Copy the data so targets never share, and mutate, the same rows
+	shaped, err := normalizeJSON(data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot copy query result")
+	}
+
+	if len(t.Select) > 0 {
+		if shaped, err = selectColumns(t.Select, shaped); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if shaped, err = applyTransform(t.Transform, shaped); err != nil {
+		return nil, nil, err
+	}
+
+	return formatOutput(t.OutputFormat, t.Column, t.KeyBy, shaped)
+}
+
+// selectColumns projects every row to the given columns. Columns missing from
+// a row are omitted rather than written as null.
+func selectColumns(columns []string, data interface{}) (interface{}, error) {
+	rows, err := toRows("select", data)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := make([]interface{}, len(rows))
+	for i, row := range rows {
+		p := make(map[string]interface{}, len(columns))
+		for _, c := range columns {
+			if v, ok := row[c]; ok {
+				p[c] = v
+			}
+		}
+		projected[i] = p
+	}
+	return projected, nil
+}
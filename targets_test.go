@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestTargets(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	type args struct {
+		input   string
+		xr      string
+		context string
+	}
+	type want struct {
+		status  string
+		context string
+		results []*fnv1.Result
+		queries int
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"SeveralTargets": {
+			reason: "One query should feed several status and context targets, each with its own shape",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "context.vnets",
+					"targets": [
+						{"path": "status.vnetIds", "select": ["id"]},
+						{"path": "status.vnetCount", "transform": {"expression": "length"}},
+						{"path": "status.[inventory.vnets]", "outputFormat": "map", "keyBy": "name", "select": ["name", "location"]}
+					]
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"existing":"kept"}}`,
+				context: `{"other":"kept"}`,
+			},
+			want: want{
+				status: `{
+					"existing": "kept",
+					"vnetIds": [{"id": "/a"}, {"id": "/b"}],
+					"vnetCount": 2,
+					"inventory.vnets": {
+						"vnet-a": {"name": "vnet-a", "location": "westeurope"},
+						"vnet-b": {"name": "vnet-b", "location": "centralus"}
+					}
+				}`,
+				context: `{
+					"azresourcegraph.fn.crossplane.io/pipeline-state": {"carriedStatus": ["existing"]},
+					"other": "kept",
+					"vnets": [
+						{"id": "/a", "name": "vnet-a", "location": "westeurope"},
+						{"id": "/b", "name": "vnet-b", "location": "centralus"}
+					]
+				}`,
+				results: []*fnv1.Result{queried},
+				queries: 1,
+			},
+		},
+		"TargetRequired": {
+			reason: "Either target or targets should be required",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count"
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+				context: `{}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "either target or targets is required",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"DuplicateTarget": {
+			reason: "A path should not be written by two targets",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets",
+					"targets": [{"path": "status.vnets", "outputFormat": "first"}]
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+				context: `{}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "target status.vnets is written more than once",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"InvalidTargetPath": {
+			reason: "Every target path should be validated",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"targets": [{"path": "status.vnets"}, {"path": "spec.vnets"}]
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`,
+				context: `{}`,
+			},
+			want: want{
+				status:  `{}`,
+				context: `{}`,
+				results: []*fnv1.Result{
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "Unrecognized target field: spec.vnets",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"SkipWhenAllTargetsHaveData": {
+			reason: "The query should be skipped only when every target has data",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"skipQueryWhenTargetHasData": true,
+					"targets": [{"path": "status.vnetCount"}, {"path": "context.vnets"}]
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetCount":1}}`,
+				context: `{"vnets":[{"id":"/a"}]}`,
+			},
+			want: want{
+				status:  `{"vnetCount": 1}`,
+				context: `{"azresourcegraph.fn.crossplane.io/pipeline-state":{"carriedStatus":["vnetCount"]},"vnets":[{"id":"/a"}]}`,
+			},
+		},
+		"QueryWhenOneTargetIsEmpty": {
+			reason: "The query should run when any target has no data",
+			args: args{
+				input: `{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"skipQueryWhenTargetHasData": true,
+					"targets": [{"path": "status.vnetCount", "transform": {"expression": "length"}}, {"path": "context.vnets", "select": ["id"]}]
+				}`,
+				xr:      `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"status":{"vnetCount":1}}`,
+				context: `{}`,
+			},
+			want: want{
+				status: `{"vnetCount": 2, "vnetCountMetadata": {
+					"lastQueryTime": "` + now.Format(time.RFC3339) + `",
+					"queryHash": "` + queryHash("Resources| count") + `",
+					"fingerprint": "` + inputFingerprint(t, `{
+						"query": "Resources| count",
+						"targets": [{"path": "status.vnetCount", "transform": {"expression": "length"}}, {"path": "context.vnets", "select": ["id"]}]
+					}`) + `",
+					"rowCount": 2,
+					"truncated": false
+				}}`,
+				context: `{"vnets":[{"id":"/a"},{"id":"/b"}]}`,
+				results: []*fnv1.Result{queried},
+				queries: 1,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			queries := 0
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						queries++
+						return armresourcegraph.ClientResourcesResponse{
+							QueryResponse: armresourcegraph.QueryResponse{Data: []interface{}{
+								map[string]interface{}{"id": "/a", "name": "vnet-a", "location": "westeurope"},
+								map[string]interface{}{"id": "/b", "name": "vnet-b", "location": "centralus"},
+							}},
+						}, nil
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.args.input),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(tc.args.xr)}},
+				Context:     resource.MustStructJSON(tc.args.context),
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.queries, queries); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want queries, +got queries:\n%s", tc.reason, diff)
+			}
+			status := desiredStatus(rsp)
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.status), status, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want status, +got status:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/jmespath-community/go-jmespath"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// transformTimeout bounds how long a transform expression may run, so a
+// runaway expression cannot block the reconciliation.
+const transformTimeout = 10 * time.Second
+
+// applyTransform evaluates the transform expression against the query result
+// and returns the value to write to the target.
+func applyTransform(t *v1beta1.Transform, data interface{}) (interface{}, error) {
+	if t == nil {
+		return data, nil
+	}
+
+	// Round-trip through JSON so the expression only sees plain JSON types
+	normalized, err := normalizeJSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot prepare query result for transform")
+	}
+
+	switch t.Language {
+	case v1beta1.TransformLanguageJQ, "":
+		return transformJQ(t.Expression, normalized)
+	case v1beta1.TransformLanguageJMESPath:
+		return transformJMESPath(t.Expression, normalized)
+	default:
+		return nil, errors.Errorf("unsupported transform language: %s", string(t.Language))
+	}
+}
+
+// transformJQ evaluates a jq expression. A single output is returned as is,
+// several outputs are collected into an array and no output results in null.
+func transformJQ(expression string, data interface{}) (interface{}, error) {
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		var perr *gojq.ParseError
+		if errors.As(err, &perr) {
+			line, column := position(expression, perr.Offset-len(perr.Token))
+			return nil, errors.Errorf("invalid jq transform at line %d, column %d: %s", line, column, err.Error())
+		}
+		return nil, errors.Wrap(err, "invalid jq transform")
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid jq transform")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transformTimeout)
+	defer cancel()
+
+	outputs, err := collectJQOutputs(code.RunWithContext(ctx, data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot evaluate jq transform %q", expression)
+	}
+
+	switch len(outputs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return outputs[0], nil
+	default:
+		return outputs, nil
+	}
+}
+
+// collectJQOutputs drains a jq iterator. halt and halt_error with a null
+// value stop the iteration without an error.
+func collectJQOutputs(iter gojq.Iter) ([]interface{}, error) {
+	outputs := []interface{}{}
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return outputs, nil
+		}
+		if err, ok := v.(error); ok {
+			var halt *gojq.HaltError
+			if errors.As(err, &halt) && halt.Value() == nil {
+				return outputs, nil
+			}
+			return nil, err
+		}
+		outputs = append(outputs, v)
+	}
+}
+
+// transformJMESPath evaluates a JMESPath expression.
+func transformJMESPath(expression string, data interface{}) (interface{}, error) {
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		var serr jmespath.SyntaxError
+		if errors.As(err, &serr) {
+			line, column := position(expression, serr.Offset)
+			return nil, errors.Errorf("invalid jmespath transform at line %d, column %d: %s", line, column, err.Error())
+		}
+		return nil, errors.Wrap(err, "invalid jmespath transform")
+	}
+
+	result, err := compiled.Search(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot evaluate jmespath transform %q", expression)
+	}
+
+	// JMESPath may return non-JSON Go types, e.g. typed slices from functions
+	return normalizeJSON(result)
+}
+
+// position converts a byte offset within an expression into a 1-based line
+// and column.
+func position(expression string, offset int) (int, int) {
+	if offset > len(expression) {
+		offset = len(expression)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := expression[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := offset - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+// normalizeJSON converts a value into the types produced by encoding/json.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
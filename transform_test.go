@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestApplyTransform(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"name": "vnet-a", "location": "westeurope", "count": 1},
+		map[string]interface{}{"name": "vnet-b", "location": "centralus", "count": 2},
+	}
+
+	type want struct {
+		data interface{}
+		err  string
+	}
+
+	cases := map[string]struct {
+		reason    string
+		transform *v1beta1.Transform
+		want      want
+	}{
+		"NoTransform": {
+			reason: "Without a transform the rows should be returned unchanged",
+			want:   want{data: rows},
+		},
+		"JQDefault": {
+			reason: "jq should be the default language and may build arbitrary objects",
+			transform: &v1beta1.Transform{
+				Expression: "map({(.name): .location}) | add",
+			},
+			want: want{data: map[string]interface{}{"vnet-a": "westeurope", "vnet-b": "centralus"}},
+		},
+		"JQMultipleOutputs": {
+			reason: "Several jq outputs should be collected into an array",
+			transform: &v1beta1.Transform{
+				Language:   v1beta1.TransformLanguageJQ,
+				Expression: ".[].name",
+			},
+			want: want{data: []interface{}{"vnet-a", "vnet-b"}},
+		},
+		"JQNoOutput": {
+			reason: "A jq expression without output should result in null",
+			transform: &v1beta1.Transform{
+				Expression: ".[] | select(.count > 5)",
+			},
+			want: want{data: nil},
+		},
+		"JQSyntaxError": {
+			reason: "jq syntax errors should report the line and column of the offending token",
+			transform: &v1beta1.Transform{
+				Expression: ".[]\n| {name: .name,, }",
+			},
+			want: want{err: `invalid jq transform at line 2, column 16: unexpected token ","`},
+		},
+		"JQEvaluationError": {
+			reason: "jq evaluation errors should name the expression",
+			transform: &v1beta1.Transform{
+				Expression: ".[0].name + 1",
+			},
+			want: want{err: `cannot evaluate jq transform ".[0].name + 1": cannot add: string ("vnet-a") and number (1)`},
+		},
+		"JMESPath": {
+			reason: "JMESPath expressions should be supported",
+			transform: &v1beta1.Transform{
+				Language:   v1beta1.TransformLanguageJMESPath,
+				Expression: "[?count > `1`].name | [0]",
+			},
+			want: want{data: "vnet-b"},
+		},
+		"JMESPathSyntaxError": {
+			reason: "JMESPath syntax errors should report the line and column",
+			transform: &v1beta1.Transform{
+				Language:   v1beta1.TransformLanguageJMESPath,
+				Expression: "[?count > `1`].name | [",
+			},
+			want: want{err: "invalid jmespath transform at line 1, column 24: SyntaxError: Incomplete expression"},
+		},
+		"JMESPathEvaluationError": {
+			reason: "JMESPath evaluation errors should name the expression",
+			transform: &v1beta1.Transform{
+				Language:   v1beta1.TransformLanguageJMESPath,
+				Expression: "abs([0].name)",
+			},
+			want: want{err: `cannot evaluate jmespath transform "abs([0].name)": invalid type for: vnet-a, expected: []functions.JpType{"number"}`},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := applyTransform(tc.transform, rows)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, gotErr); diff != "" {
+				t.Errorf("%s\napplyTransform(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.data, got); err == nil && diff != "" {
+				t.Errorf("%s\napplyTransform(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestTransformTarget(t *testing.T) {
+	type want struct {
+		context string
+		results []*fnv1.Result
+	}
+
+	cases := map[string]struct {
+		reason string
+		input  string
+		want   want
+	}{
+		"TransformedToContext": {
+			reason: "The transformed value should be written to the target",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"target": "context.vnet",
+				"transform": {"expression": ".[0] | {name, resourceGroup}"}
+			}`,
+			want: want{
+				context: `{"vnet": {"name": "vnet-a", "resourceGroup": "rg-a"}}`,
+				results: []*fnv1.Result{
+					queried,
+				},
+			},
+		},
+		"InvalidExpression": {
+			reason: "An invalid expression should be a fatal result and leave the target untouched",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"target": "context.vnet",
+				"transform": {"expression": ".[0] | {name"}
+			}`,
+			want: want{
+				context: `{}`,
+				results: []*fnv1.Result{
+					queried,
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  "target context.vnet: invalid jq transform at line 1, column 13: unexpected EOF",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+		"EvaluationError": {
+			reason: "An expression failing on the query result should be a fatal result naming the expression and the target",
+			input: `{
+				"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+				"kind": "Input",
+				"query": "Resources| count",
+				"target": "context.vnet",
+				"transform": {"expression": ".[0].name + 1"}
+			}`,
+			want: want{
+				context: `{}`,
+				results: []*fnv1.Result{
+					queried,
+					{
+						Severity: fnv1.Severity_SEVERITY_FATAL,
+						Message:  `target context.vnet: cannot evaluate jq transform ".[0].name + 1": cannot add: string ("vnet-a") and number (1)`,
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: mockRows(map[string]interface{}{"name": "vnet-a", "resourceGroup": "rg-a", "location": "westeurope"}),
+				log:        logging.NewNopLogger(),
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta:        &fnv1.RequestMeta{Tag: "hello"},
+				Input:       resource.MustStructJSON(tc.input),
+				Context:     resource.MustStructJSON(`{}`),
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.results, rsp.GetResults(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want results, +got results:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(resource.MustStructJSON(tc.want.context), rsp.GetContext(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
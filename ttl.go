@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// scheduleHorizon is how far ahead the next schedule window is looked for when
+// there is no maximum TTL.
+const scheduleHorizon = 8 * 24 * time.Hour
+
+// setTTL sets how long Crossplane may cache the response before calling the
+// function again, bounded by the minimum and maximum TTL.
+func (f *Function) setTTL(rsp *fnv1.RunFunctionResponse, ttl time.Duration) {
+	if ttl < f.minTTL {
+		ttl = f.minTTL
+	}
+	if f.maxTTL > 0 && ttl > f.maxTTL {
+		ttl = f.maxTTL
+	}
+	if rsp.GetMeta() == nil {
+		rsp.Meta = &fnv1.ResponseMeta{}
+	}
+	rsp.Meta.Ttl = durationpb.New(ttl)
+}
+
+// setQueryTTL sets the TTL to when the next query is due after the one at
+// last: once the interval passed and within the schedule. Without either the
+// default TTL is kept within the bounds.
+func (f *Function) setQueryTTL(req *fnv1.RunFunctionRequest, in *v1beta1.Input, rsp *fnv1.RunFunctionResponse, last time.Time) {
+	now := f.clock()
+	due := now
+	if intervalSet(in) {
+		if next := last.Add(f.queryInterval(req, in)); next.After(due) {
+			due = next
+		}
+	}
+	if len(in.Schedule) > 0 {
+		horizon := scheduleHorizon
+		if f.maxTTL > 0 && f.maxTTL < horizon {
+			horizon = f.maxTTL
+		}
+		due = nextInSchedule(in.Schedule, due, horizon)
+	}
+
+	ttl := due.Sub(now)
+	if ttl <= 0 {
+		ttl = response.DefaultTTL
+	}
+	f.setTTL(rsp, ttl)
+}
+
+// retryAfter returns how long Azure Resource Graph asked to wait before the
+// next query, if the error carries such a hint. Throttled requests carry the
+// time until the quota resets, other requests may carry a Retry-After header.
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	var re *azcore.ResponseError
+	if !errors.As(err, &re) || re.RawResponse == nil {
+		return 0, false
+	}
+	h := re.RawResponse.Header
+
+	if d, ok := quotaResetsAfter(h.Get("x-ms-user-quota-resets-after")); ok {
+		return d, true
+	}
+
+	// Either a number of seconds or an HTTP date
+	v := h.Get("Retry-After")
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// quotaResetsAfter parses the hh:mm:ss until the user quota resets.
+func quotaResetsAfter(v string) (time.Duration, bool) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	d, err := time.ParseDuration(parts[0] + "h" + parts[1] + "m" + parts[2] + "s")
+	return d, err == nil && d > 0
+}
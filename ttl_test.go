@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/google/go-cmp/cmp"
+	"github.com/upbound/function-azresourcegraph/input/v1beta1"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+func TestSetQueryTTL(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 1, 3, 12, 30, 0, 0, time.UTC)
+
+	type args struct {
+		minTTL time.Duration
+		maxTTL time.Duration
+		in     *v1beta1.Input
+		last   time.Time
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   time.Duration
+	}{
+		"Default": {
+			reason: "Without an interval or a schedule the default TTL should be kept",
+			args: args{
+				in:   &v1beta1.Input{},
+				last: now,
+			},
+			want: response.DefaultTTL,
+		},
+		"RemainingInterval": {
+			reason: "The TTL should be the time left until the interval passes",
+			args: args{
+				in:   &v1beta1.Input{QueryInterval: "1h"},
+				last: now.Add(-20 * time.Minute),
+			},
+			want: 40 * time.Minute,
+		},
+		"IntervalPassed": {
+			reason: "The default TTL should be used once the interval passed",
+			args: args{
+				in:   &v1beta1.Input{QueryIntervalMinutes: to.Ptr(10)},
+				last: now.Add(-20 * time.Minute),
+			},
+			want: response.DefaultTTL,
+		},
+		"MaxTTL": {
+			reason: "The TTL should not exceed the maximum",
+			args: args{
+				maxTTL: 30 * time.Minute,
+				in:     &v1beta1.Input{QueryInterval: "1h"},
+				last:   now,
+			},
+			want: 30 * time.Minute,
+		},
+		"MinTTL": {
+			reason: "The TTL should not be below the minimum",
+			args: args{
+				minTTL: 2 * time.Minute,
+				in:     &v1beta1.Input{},
+				last:   now,
+			},
+			want: 2 * time.Minute,
+		},
+		"NextScheduleWindow": {
+			reason: "The TTL should last until the next schedule window",
+			args: args{
+				in:   &v1beta1.Input{Schedule: []v1beta1.ScheduleWindow{{Hours: "14-15"}}},
+				last: now,
+			},
+			want: 90 * time.Minute,
+		},
+		"IntervalEndsOutsideSchedule": {
+			reason: "The TTL should last until the first schedule window after the interval passes",
+			args: args{
+				in: &v1beta1.Input{
+					QueryInterval: "1h",
+					Schedule:      []v1beta1.ScheduleWindow{{Cron: "* 12 * * *"}},
+				},
+				last: now,
+			},
+			want: 23*time.Hour + 30*time.Minute,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				log:    logging.NewNopLogger(),
+				minTTL: tc.args.minTTL,
+				maxTTL: tc.args.maxTTL,
+				now:    func() time.Time { return now },
+			}
+			req := &fnv1.RunFunctionRequest{
+				Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`)}},
+			}
+			rsp := &fnv1.RunFunctionResponse{}
+
+			f.setQueryTTL(req, tc.args.in, rsp, tc.args.last)
+			if diff := cmp.Diff(tc.want, rsp.GetMeta().GetTtl().AsDuration()); diff != "" {
+				t.Errorf("%s\nsetQueryTTL(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRetryAfterTTL(t *testing.T) {
+	now := time.Date(2024, 1, 3, 12, 30, 0, 0, time.UTC)
+	throttled := func(header http.Header) error {
+		return errors.Wrap(&azcore.ResponseError{
+			StatusCode:  http.StatusTooManyRequests,
+			RawResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header},
+		}, "failed to finish the request")
+	}
+
+	cases := map[string]struct {
+		reason string
+		err    error
+		want   time.Duration
+	}{
+		"QuotaResetsAfter": {
+			reason: "The TTL should last until the Azure Resource Graph quota resets",
+			err:    throttled(http.Header{"X-Ms-User-Quota-Resets-After": []string{"00:00:05"}}),
+			want:   5 * time.Second,
+		},
+		"RetryAfterSeconds": {
+			reason: "The TTL should honor a Retry-After header in seconds",
+			err:    throttled(http.Header{"Retry-After": []string{"30"}}),
+			want:   30 * time.Second,
+		},
+		"RetryAfterDate": {
+			reason: "The TTL should honor a Retry-After header with a date",
+			err:    throttled(http.Header{"Retry-After": []string{now.Add(2 * time.Minute).Format(http.TimeFormat)}}),
+			want:   2 * time.Minute,
+		},
+		"NoHint": {
+			reason: "The default TTL should be kept without a hint",
+			err:    errors.New("boom"),
+			want:   response.DefaultTTL,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &Function{
+				azureQuery: &MockAzureQuery{
+					AzQueryFunc: func(_ context.Context, _ interface{}, _ *v1beta1.Input, _ logging.Logger) (armresourcegraph.ClientResourcesResponse, error) {
+						return armresourcegraph.ClientResourcesResponse{}, tc.err
+					},
+				},
+				log: logging.NewNopLogger(),
+				now: func() time.Time { return now },
+			}
+
+			rsp, err := f.RunFunction(context.Background(), &fnv1.RunFunctionRequest{
+				Meta: &fnv1.RequestMeta{Tag: "hello"},
+				Input: resource.MustStructJSON(`{
+					"apiVersion": "azresourcegraph.fn.crossplane.io/v1beta1",
+					"kind": "Input",
+					"query": "Resources| count",
+					"target": "status.vnets"
+				}`),
+				Observed:    &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"}}`)}},
+				Credentials: testCredentials(),
+			})
+			if err != nil {
+				t.Fatalf("%s\nRunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(durationpb.New(tc.want), rsp.GetMeta().GetTtl(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want TTL, +got TTL:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}